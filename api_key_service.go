@@ -0,0 +1,54 @@
+package api_gateway
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// APIKey describes the key of a machine client, the key itself is only known to the client.
+// Keys without ExpiresAt do not expire.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Enabled   bool       `json:"enabled"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// APIKeyService verifies and manages API keys. Keys are returned in plain text
+// only when created or rotated.
+type APIKeyService interface {
+	VerifyKey(context.Context, string) (Identity, error)
+	CreateKey(context.Context, APIKey) (APIKey, string, error)
+	ListKeys(context.Context) ([]APIKey, error)
+	RotateKey(context.Context, string) (APIKey, string, error)
+	RevokeKey(context.Context, string) (APIKey, error)
+}
+
+// APIKeyNotFoundError is returned for operations on keys that do not exist.
+type APIKeyNotFoundError struct {
+	ID string
+}
+
+func (e APIKeyNotFoundError) Error() string {
+	return "api key " + e.ID + " not found"
+}
+
+func (e APIKeyNotFoundError) StatusCode() int {
+	return http.StatusNotFound
+}
+
+// InvalidAPIKeyError is returned when a key can not be created or changed as requested.
+type InvalidAPIKeyError struct {
+	Reason string
+}
+
+func (e InvalidAPIKeyError) Error() string {
+	return "invalid api key: " + e.Reason
+}
+
+func (e InvalidAPIKeyError) StatusCode() int {
+	return http.StatusBadRequest
+}
@@ -5,26 +5,21 @@ import (
 	. "api-gateway/endpoints"
 	. "api-gateway/middleware"
 	. "api-gateway/registration"
+	. "api-gateway/routing"
 	. "api-gateway/services"
-	. "api-gateway/transports"
+	. "api-gateway/upstreams"
 
 	"flag"
 	"fmt"
 	"github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/metrics"
-	. "github.com/go-kit/kit/ratelimit"
-	httptransport "github.com/go-kit/kit/transport/http"
-	"golang.org/x/time/rate"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 
 	"bufio"
 	"context"
-	"github.com/go-kit/kit/endpoint"
-	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
-	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"os/signal"
 	"time"
@@ -32,21 +27,6 @@ import (
 
 var (
 	configFileName string
-
-	issueTokenCounter  metrics.Counter
-	verifyTokenCounter metrics.Counter
-	revokeTokenCounter metrics.Counter
-	healthCheckCounter metrics.Counter
-
-	issueTokenHistogram  metrics.Histogram
-	verifyTokenHistogram metrics.Histogram
-	revokeTokenHistogram metrics.Histogram
-	healthCheckHistogram metrics.Histogram
-
-	issueTokenLabel  = "issueToken"
-	verifyTokenLabel = "verifyToken"
-	revokeTokenLabel = "revokeToken"
-	healthCheckLabel = "healthCheck"
 )
 
 func init() {
@@ -81,85 +61,97 @@ func main() {
 
 	var tokenService TokenService
 
+	upstreamRegistry, err := NewRegistry(config.Upstreams, NewConsulClient(config, logger),
+		NewMetrics(config.Main.ServiceName), logger)
+
 	if err != nil {
-		logger.Log(err)
+		panic(err)
 	}
 
-	issueTokenProxyURL := &url.URL{
-		Scheme: config.TokenService.Protocol,
-		Host:   config.TokenService.ListenStr,
-		Path:   config.TokenService.IssueTokenPath,
-	}
+	defer upstreamRegistry.Close()
 
-	verifyTokenProxyURL := &url.URL{
-		Scheme: config.TokenService.Protocol,
-		Host:   config.TokenService.ListenStr,
-		Path:   config.TokenService.VerifyTokenPath,
+	tokenCluster, err := upstreamRegistry.Get(config.TokenService.Upstream)
+
+	if err != nil {
+		panic(err)
 	}
 
-	revokeTokenProxyURL := &url.URL{
-		Scheme: config.TokenService.Protocol,
-		Host:   config.TokenService.ListenStr,
-		Path:   config.TokenService.RevokeTokenPath,
+	tokenService = TokenProxyService{
+		IssueTokenEndpoint:  tokenCluster.Endpoint(config.TokenService.IssueTokenPath, MakeProxyIssueTokenEndpoint),
+		VerifyTokenEndpoint: tokenCluster.Endpoint(config.TokenService.VerifyTokenPath, MakeProxyVerifyTokenEndpoint),
+		RevokeTokenEndpoint: tokenCluster.Endpoint(config.TokenService.RevokeTokenPath, MakeProxyRevokeTokenEndpoint),
+		Healthy:             tokenCluster.Healthy,
 	}
 
-	issueTokenEndpoint := MakeProxyIssueTokenEndpoint(issueTokenProxyURL)
-	verifyTokenEndpoint := MakeProxyVerifyTokenEndpoint(verifyTokenProxyURL)
-	revokeTokenEndpoint := MakeProxyRevokeTokenEndpoint(revokeTokenProxyURL)
-	healthCheckEndpoint := MakeHealthCheckEndpoint(tokenService)
+	// Tokens are verified locally when the keys they are signed with are known
+	if jwtConfig := config.TokenService.JWT; len(jwtConfig.JWKSFile) > 0 || len(jwtConfig.JWKSURL) > 0 {
+		jwtTokenService, err := NewJWTTokenService(tokenService, jwtConfig, log.With(logger, "component", "jwks"))
 
-	issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint =
-		wrapLogging(issueTokenEndpoint, logger, verifyTokenEndpoint, revokeTokenEndpoint)
-	issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint =
-		wrapLimit(issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint)
-	issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint =
-		wrapPrometheus(config, issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint, healthCheckEndpoint)
+		if err != nil {
+			panic(err)
+		}
 
-	tokenService = TokenProxyService{
-		IssueTokenEndpoint:  issueTokenEndpoint,
-		VerifyTokenEndpoint: verifyTokenEndpoint,
-		RevokeTokenEndpoint: revokeTokenEndpoint,
-		HealthCheckEndpoint: healthCheckEndpoint,
+		defer jwtTokenService.Close()
+		tokenService = jwtTokenService
+	}
+
+	var tokenCache *CachingTokenService
+
+	if cacheConfig := config.TokenService.Cache; cacheConfig.MaxEntries > 0 && cacheConfig.TTL.Duration > 0 {
+		tokenCache = NewCachingTokenService(tokenService, cacheConfig.MaxEntries, cacheConfig.TTL.Duration,
+			cacheConfig.NegativeTTL.Duration, kitprometheus.NewCounterFrom(
+				stdprometheus.CounterOpts{
+					Name:      "token_cache_counter",
+					Subsystem: config.Main.ServiceName,
+					Help:      "Token verification cache hits, misses, evictions and invalidations",
+				},
+				[]string{"event"}))
+		tokenService = tokenCache
 	}
 
 	tokenService = NewLoggingMiddleWare(tokenService, logger)
 
-	issueTokenHandler := httptransport.NewServer(
-		issueTokenEndpoint,
-		DecodeIssueTokenRequest,
-		EncodeResponse,
-	)
-
-	verifyTokenHandler := httptransport.NewServer(
-		verifyTokenEndpoint,
-		DecodeVerifyTokenRequest,
-		EncodeResponse,
-	)
-
-	revokerTokenHandler := httptransport.NewServer(
-		revokeTokenEndpoint,
-		DecodeRevokeTokenRequest,
-		EncodeResponse,
-	)
-
-	healthCheckHandler := httptransport.NewServer(
-		healthCheckEndpoint,
-		DecodeHealthRequest,
-		httptransport.EncodeJSONResponse,
-	)
-
-	http.Handle("/metrics", promhttp.Handler())
-	http.Handle("/token", issueTokenHandler)
-	http.Handle("/token/verify", verifyTokenHandler)
-	http.Handle("/token/revoke", revokerTokenHandler)
-	http.Handle("/health", healthCheckHandler)
+	// Build handlers from the route table
+	builder := NewBuilder(config, logger, tokenService, upstreamRegistry)
+
+	if tokenCache != nil {
+		builder.OnTokenRevoked(tokenCache.Invalidate)
+	}
+
+	// API keys of machine clients are managed by the gateway itself
+	if len(config.APIKeys.Store) > 0 {
+		keyStore, err := NewKeyStore(config.APIKeys)
+
+		if err != nil {
+			panic(err)
+		}
+
+		apiKeyService := NewStoredKeyService(keyStore)
+		defer apiKeyService.Close()
+		builder.UseAPIKeys(apiKeyService)
+	}
+
+	router, err := builder.Build()
+
+	if err != nil {
+		panic(err)
+	}
+
+	for _, route := range router.Routes() {
+		logger.Log("main", fmt.Sprintf("Route %s hosts %v path %q prefix %q", route.Name, route.Hosts, route.Path, route.Prefix))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", router)
 
 	// Register in consul for service discovery
 	registrar := NewRegistrar(config, logger)
 	registrar.Register()
 
 	server := &http.Server{
-		Addr: config.TokenService.ListenStr,
+		Addr:         config.Gateway.ListenStr,
+		Handler:      mux,
 		ReadTimeout:  config.Server.ReadTimeout * time.Second,
 		WriteTimeout: config.Server.WriteTimeout * time.Second,
 		IdleTimeout:  config.Server.IdleTimeout * time.Second,
@@ -178,105 +170,8 @@ func main() {
 		server.Shutdown(shutdownCtx)
 	}()
 
-	logger.Log("main", fmt.Sprintf("Start listen port %s", config.TokenService.ListenStr))
+	logger.Log("main", fmt.Sprintf("Start listen port %s", config.Gateway.ListenStr))
 	if err := server.ListenAndServe(); err != nil {
 		logger.Log("error", err)
 	}
 }
-
-func wrapLogging(issueTokenEndpoint endpoint.Endpoint, logger log.Logger, verifyTokenEndpoint endpoint.Endpoint,
-	revokeTokenEndpoint endpoint.Endpoint) (endpoint.Endpoint, endpoint.Endpoint, endpoint.Endpoint) {
-	issueTokenEndpoint = LoggingMiddleware(log.With(logger, "method", "IssueToken"),
-		"issueTokenEndpoint")(issueTokenEndpoint)
-	verifyTokenEndpoint = LoggingMiddleware(log.With(logger, "method", "VerifyToken"),
-		"verifyTokenEndpoint")(verifyTokenEndpoint)
-	revokeTokenEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokeToken"),
-		"revokeTokenEndpoint")(revokeTokenEndpoint)
-
-	return issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint
-}
-
-func wrapLimit(issueTokenEndpoint endpoint.Endpoint, verifyTokenEndpoint endpoint.Endpoint,
-	revokeTokenEndpoint endpoint.Endpoint) (endpoint.Endpoint, endpoint.Endpoint, endpoint.Endpoint) {
-	rateLimitMiddleware10 := NewErroringLimiter(rate.NewLimiter(10, 1))
-	rateLimitMiddleware5 := NewErroringLimiter(rate.NewLimiter(5, 1))
-	rateLimitMiddleware1 := NewErroringLimiter(rate.NewLimiter(1, 1))
-
-	issueTokenEndpoint = rateLimitMiddleware10(issueTokenEndpoint)
-	verifyTokenEndpoint = rateLimitMiddleware5(verifyTokenEndpoint)
-	revokeTokenEndpoint = rateLimitMiddleware1(revokeTokenEndpoint)
-
-	return issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint
-}
-
-func wrapPrometheus(config *TomlConfig, issueTokenEndpoint endpoint.Endpoint, verifyTokenEndpoint endpoint.Endpoint,
-	revokeTokenEndpoint endpoint.Endpoint, healthCheckEndpoint endpoint.Endpoint) (endpoint.Endpoint, endpoint.Endpoint, endpoint.Endpoint) {
-	// Issue token
-	issueTokenCounter = kitprometheus.NewCounterFrom(
-		stdprometheus.CounterOpts{
-			Name:      "issue_token_counter",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Issue token counter"},
-		[]string{issueTokenLabel})
-	issueTokenHistogram = kitprometheus.NewHistogramFrom(
-		stdprometheus.HistogramOpts{
-			Name:      "issue_token_histogram",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Issue token histogram"},
-		[]string{issueTokenLabel})
-	issueTokenEndpoint = MetricsMiddleware(issueTokenCounter, issueTokenHistogram, issueTokenLabel)(issueTokenEndpoint)
-
-	// Verify token
-	verifyTokenCounter = kitprometheus.NewCounterFrom(
-		stdprometheus.CounterOpts{
-			Name:      "verify_token_counter",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Verify token counter",
-		}, []string{verifyTokenLabel})
-	verifyTokenHistogram = kitprometheus.NewHistogramFrom(
-		stdprometheus.HistogramOpts{
-			Name:      "verify_token_histogram",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Verify token histogram",
-		}, []string{verifyTokenLabel})
-	verifyTokenEndpoint = MetricsMiddleware(verifyTokenCounter, verifyTokenHistogram, verifyTokenLabel)(verifyTokenEndpoint)
-
-	// Revoke token
-	revokeTokenCounter = kitprometheus.NewCounterFrom(
-		stdprometheus.CounterOpts{
-			Name:      "revoke_token_counter",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Revoke token counter",
-		},
-		[]string{revokeTokenLabel})
-	revokeTokenHistogram = kitprometheus.NewHistogramFrom(
-		stdprometheus.HistogramOpts{
-			Name:      "revoke_token_histogram",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Revoke token histogram",
-		},
-		[]string{revokeTokenLabel})
-
-	revokeTokenEndpoint = MetricsMiddleware(revokeTokenCounter, revokeTokenHistogram, revokeTokenLabel)(revokeTokenEndpoint)
-
-	// Revoke token
-	healthCheckCounter = kitprometheus.NewCounterFrom(
-		stdprometheus.CounterOpts{
-			Name:      "healt_check_counter",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Health check counter",
-		},
-		[]string{healthCheckLabel})
-
-	healthCheckHistogram = kitprometheus.NewHistogramFrom(
-		stdprometheus.HistogramOpts{
-			Name:      "health_check_histogram",
-			Subsystem: config.Main.ServiceName,
-			Help:      "Health check histogram",
-		},
-		[]string{healthCheckLabel})
-
-	healthCheckEndpoint = MetricsMiddleware(healthCheckCounter, healthCheckHistogram, healthCheckLabel)(healthCheckEndpoint)
-
-	return issueTokenEndpoint, verifyTokenEndpoint, revokeTokenEndpoint
-}
@@ -9,9 +9,14 @@ var config_instance *TomlConfig
 
 type TomlConfig struct {
 	Main             MainConfig
+	Gateway          GatewayConfig
 	Server           ServerConfig
 	TokenService     TokenServiceConfig
 	ServiceDiscovery ServiceDiscoveryConfig
+	LoadShedding     LoadSheddingConfig
+	APIKeys          APIKeysConfig
+	Upstreams        []UpstreamConfig
+	Routes           []RouteConfig
 }
 
 type MainConfig struct {
@@ -21,6 +26,24 @@ type MainConfig struct {
 	LogFile     string
 }
 
+// GatewayConfig is the address the gateway itself listens on.
+type GatewayConfig struct {
+	ListenStr string
+}
+
+// LoadSheddingConfig rejects requests of lower priority classes while the gateway is
+// overloaded, that is while the scheduling delay of its goroutines sampled every
+// Interval nears MaxSchedulingDelay or the number of goroutines nears MaxGoroutines.
+// Routes set their class, clients from TrustedNetworks may pick another one with
+// PriorityHeader. It is disabled while neither limit is set.
+type LoadSheddingConfig struct {
+	MaxSchedulingDelay Duration
+	MaxGoroutines      int
+	Interval           Duration
+	PriorityHeader     string
+	TrustedNetworks    []string
+}
+
 type ServerConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
@@ -28,12 +51,47 @@ type ServerConfig struct {
 	ShutdownTimeout time.Duration
 }
 
+// TokenServiceConfig refers to the upstream cluster serving the token API.
 type TokenServiceConfig struct {
-	ListenStr       string
-	Protocol        string
+	Upstream        string
 	IssueTokenPath  string
 	VerifyTokenPath string
 	RevokeTokenPath string
+	JWT             JWTConfig
+	Cache           TokenCacheConfig
+}
+
+// TokenCacheConfig keeps up to MaxEntries results of token verification for TTL,
+// tokens rejected as invalid for NegativeTTL. Tokens revoked through the gateway are
+// dropped at once. It is disabled while MaxEntries or TTL is zero.
+type TokenCacheConfig struct {
+	MaxEntries  int
+	TTL         Duration
+	NegativeTTL Duration
+}
+
+// APIKeysConfig keeps the API keys of machine clients in Store, either "file" for a
+// JSON file or "bolt" for an embedded database at Path. Only hashes of the keys are
+// stored. It is disabled while Store is empty.
+type APIKeysConfig struct {
+	Store string
+	Path  string
+}
+
+// JWTConfig makes the gateway verify tokens as signed JWTs itself instead of calling
+// the token service. Keys are loaded from JWKSFile or JWKSURL and reloaded every
+// RefreshInterval. Tokens must be issued by Issuer for one of Audiences when these
+// are set, time claims are checked allowing for ClockSkew. With CheckRevocation valid
+// tokens are still verified by the token service to reject revoked ones. It is enabled
+// while either JWKSFile or JWKSURL is set.
+type JWTConfig struct {
+	JWKSFile        string
+	JWKSURL         string
+	RefreshInterval Duration
+	Issuer          string
+	Audiences       []string
+	ClockSkew       Duration
+	CheckRevocation bool
 }
 
 type ServiceDiscoveryConfig struct {
@@ -45,6 +103,279 @@ type ServiceDiscoveryConfig struct {
 	Timeout           string
 }
 
+// UpstreamConfig is a named cluster of upstream instances.
+// Instances are either the static Addresses or, with Discovery set to "consul",
+// the healthy instances of ServiceName. Balancer is "round_robin" or "random".
+type UpstreamConfig struct {
+	Name             string
+	Scheme           string
+	Addresses        []string
+	BasePath         string
+	Discovery        string
+	ServiceName      string
+	Tags             []string
+	PassingOnly      bool
+	Balancer         string
+	Client           UpstreamClientConfig
+	CircuitBreaker   CircuitBreakerConfig
+	Bulkhead         BulkheadConfig
+	OutlierDetection OutlierDetectionConfig
+	HealthCheck      HealthCheckConfig
+	AdaptiveLimit    AdaptiveLimitConfig
+}
+
+// UpstreamClientConfig tunes the HTTP client used to call a cluster. DialTimeout limits
+// connecting, ResponseHeaderTimeout waiting for the response once the request is sent
+// and Timeout the whole call including reading the response body.
+type UpstreamClientConfig struct {
+	Timeout               Duration
+	DialTimeout           Duration
+	ResponseHeaderTimeout Duration
+	IdleConnTimeout       Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	InsecureSkipVerify    bool
+}
+
+// CircuitBreakerConfig trips the breaker of an upstream when at least MinRequests
+// were made during Interval and FailureRatio of them failed. The breaker stays open
+// for OpenDuration and then lets HalfOpenRequests probes through. It is disabled
+// while FailureRatio is zero.
+type CircuitBreakerConfig struct {
+	FailureRatio     float64
+	MinRequests      uint32
+	Interval         Duration
+	OpenDuration     Duration
+	HalfOpenRequests uint32
+}
+
+// AdaptiveLimitConfig limits concurrent calls to an upstream to a limit between
+// MinLimit and MaxLimit starting at InitialLimit. The limit grows while calls are no
+// slower than Tolerance times the long term average round trip time, shrinks as they
+// slow down and is multiplied by Backoff on every failure. Calls over the limit are
+// answered with 503. It is disabled while MaxLimit is zero.
+type AdaptiveLimitConfig struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	Tolerance    float64
+	Backoff      float64
+}
+
+// HealthCheckConfig probes every instance of an upstream with GET Path each Interval,
+// a probe fails on errors, non 2xx replies and after Timeout. Instances are taken out
+// of balancing after UnhealthyThreshold failed probes in a row and come back after
+// HealthyThreshold successful ones. It is disabled while Path is empty.
+type HealthCheckConfig struct {
+	Path               string
+	Interval           Duration
+	Timeout            Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// OutlierDetectionConfig ejects an instance of an upstream from balancing after
+// ConsecutiveErrors failed calls in a row, or when after MinRequests calls its average
+// latency exceeds LatencyFactor times the average of the other instances. Ejected
+// instances come back after BaseEjectionTime, doubled with every further ejection up
+// to MaxEjectionTime. At most MaxEjectionPercent of the instances are ejected at once.
+// It is disabled while neither ConsecutiveErrors nor LatencyFactor is set.
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int
+	LatencyFactor      float64
+	MinRequests        int
+	BaseEjectionTime   Duration
+	MaxEjectionTime    Duration
+	MaxEjectionPercent float64
+}
+
+// BulkheadConfig limits concurrent calls to MaxInFlight, up to MaxQueued more calls
+// wait at most QueueTimeout for a free slot. Rejected calls are answered with 503 and
+// Retry-After. It is disabled while MaxInFlight is zero.
+type BulkheadConfig struct {
+	MaxInFlight  int
+	MaxQueued    int
+	QueueTimeout Duration
+	RetryAfter   Duration
+}
+
+// RouteConfig describes a single entry of the gateway route table.
+// Either Path (exact match) or Prefix must be set. Hosts may contain wildcards
+// like "*.example.com", Headers and Query values must match exactly or be "*"
+// to only require presence. Handler selects how requests are decoded and forwarded,
+// Middleware lists endpoint middlewares applied from the innermost to the outermost one.
+// Priority is "critical", "normal" (the default) or "bulk" and decides which requests
+// are shed first under overload.
+type RouteConfig struct {
+	Name       string
+	Path       string
+	Prefix     string
+	Methods    []string
+	Hosts      []string
+	Headers    map[string]string
+	Query      map[string]string
+	Upstream   string
+	Handler    string
+	Middleware []string
+	RateLimit  float64
+	RateBurst  int
+	Rewrite    RewriteConfig
+	Split      []SplitConfig
+	Sticky     StickyConfig
+	Mirror     MirrorConfig
+	Calls      []AggregateCallConfig
+	Versions   []VersionConfig
+	Retry      RetryConfig
+	Timeout    TimeoutConfig
+	Bulkhead   BulkheadConfig
+	Hedge      HedgeConfig
+	Fallback   FallbackConfig
+	Priority   string
+	Auth       AuthConfig
+}
+
+// TimeoutConfig limits how long a route waits for its upstream. Response limits
+// every attempt until the upstream starts responding, Total limits the whole call
+// including retries. The remaining time is passed to upstreams in X-Request-Timeout-Ms.
+type TimeoutConfig struct {
+	Response Duration
+	Total    Duration
+}
+
+// AuthConfig protects a route with bearer tokens verified by the token service when
+// Required is set. Tokens are taken from the Authorization header or else from Cookie.
+// Requests without a valid token are answered with 401 and a WWW-Authenticate challenge
+// of Realm, tokens lacking any of Scopes with 403. API keys are accepted instead of tokens
+// from the APIKeyHeader header or the APIKeyQuery query parameter when these are set,
+// the key is not forwarded to the upstream. The verified subject and scopes are
+// passed to the upstream in X-Auth-Subject and X-Auth-Scopes, copies sent by clients
+// are dropped on every route.
+type AuthConfig struct {
+	Required     bool
+	Cookie       string
+	Realm        string
+	Scopes       []string
+	APIKeyHeader string
+	APIKeyQuery  string
+}
+
+// FallbackConfig serves a route when its upstream fails or its breaker is open.
+// Successful replies are kept for StaleAge and served when later calls fail, otherwise
+// the request is sent to the alternate Upstream and at last answered with Status,
+// ContentType and Body if Status is set. Only MaxEntries replies with bodies up to
+// MaxBodyBytes are kept. Replies served by a fallback carry the X-Degraded header.
+type FallbackConfig struct {
+	StaleAge     Duration
+	MaxEntries   int
+	MaxBodyBytes int64
+	Upstream     string
+	Status       int
+	ContentType  string
+	Body         string
+}
+
+// HedgeConfig sends a second request of idempotent calls to another instance
+// when the first one does not answer within Delay or within the Percentile
+// of the observed latency, whichever answers first wins and the other is cancelled.
+// At most MaxPercent of the calls are hedged. It is disabled while neither Delay
+// nor Percentile is set.
+type HedgeConfig struct {
+	Delay        Duration
+	Percentile   float64
+	MaxPercent   float64
+	MaxBodyBytes int64
+}
+
+// RetryConfig repeats failed upstream calls of a route up to MaxAttempts times in total.
+// Calls are retried on RetryableStatusCodes and on RetryOn error classes: "connect",
+// "timeout" and "reset". Requests that are not idempotent are retried only with
+// NonIdempotent set or when the client sends an Idempotency-Key. Bodies of proxied
+// requests up to MaxBodyBytes are buffered to be sent again.
+type RetryConfig struct {
+	MaxAttempts          int
+	PerTryTimeout        Duration
+	BaseBackoff          Duration
+	MaxBackoff           Duration
+	RetryableStatusCodes []int
+	RetryOn              []string
+	NonIdempotent        bool
+	MaxBodyBytes         int64
+}
+
+// VersionConfig is a version of a route served by its own Upstream. Clients pick
+// a version with a path prefix like /v2/token, an Accept-Version header or a version
+// parameter of the Accept media type, others get the Default version. Deprecated
+// versions are answered with a Deprecation header and Sunset, an RFC 3339 time, if set.
+type VersionConfig struct {
+	Name       string
+	Upstream   string
+	Default    bool
+	Deprecated bool
+	Sunset     string
+}
+
+// MirrorConfig shadows Percent of the route requests to Upstream,
+// shadow responses are only compared with the primary ones and discarded.
+// Request bodies larger than MaxBodyBytes are not mirrored.
+type MirrorConfig struct {
+	Upstream     string
+	Percent      float64
+	Timeout      Duration
+	MaxBodyBytes int64
+}
+
+// AggregateCallConfig is one of the upstream calls of an "aggregate" route, its JSON
+// response is merged into the route response under Name. Path may refer to query
+// parameters of the incoming request as {query.name} and to fields of responses of
+// other calls as {call.field.subfield}, such calls wait for the ones they refer to.
+// Failures of Optional calls are reported next to the results instead of failing the route.
+type AggregateCallConfig struct {
+	Name     string
+	Upstream string
+	Method   string
+	Path     string
+	Timeout  Duration
+	Optional bool
+}
+
+// SplitConfig is a variant of a route that gets Weight parts of its traffic.
+// Name labels the variant in metrics and defaults to Upstream.
+type SplitConfig struct {
+	Name     string
+	Upstream string
+	Weight   int
+}
+
+// StickyConfig keeps clients on the same split variant by hashing
+// the value of Header or Cookie, whichever is present.
+type StickyConfig struct {
+	Header string
+	Cookie string
+}
+
+// RewriteConfig changes the request path before it is forwarded upstream.
+// Rules are applied in order: StripPrefix, Regex with Replacement, AddPrefix. StripPrefix
+// only matches whole segments, "/api" is stripped from "/api/orders" but not from "/apiary".
+// Replacement may refer to capture groups of Regex as $1 or ${name}.
+type RewriteConfig struct {
+	StripPrefix string
+	AddPrefix   string
+	Regex       string
+	Replacement string
+}
+
+// Duration is a time.Duration that is written in the config as a string like "1.5s".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+
+	return err
+}
+
 func GetConfig() *TomlConfig {
 	return config_instance
 }
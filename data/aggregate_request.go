@@ -0,0 +1,12 @@
+package data
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// AggregateRequest is what calls of an aggregate route can use from the incoming request.
+type AggregateRequest struct {
+	Query  url.Values
+	Header http.Header
+}
@@ -0,0 +1,7 @@
+package data
+
+// AggregateErrorsKey holds failures of optional calls in an AggregateResponse.
+const AggregateErrorsKey = "errors"
+
+// AggregateResponse maps call names to their decoded JSON responses.
+type AggregateResponse map[string]interface{}
@@ -0,0 +1,20 @@
+package data
+
+import "time"
+
+// CreateAPIKeyRequest asks for a key of Owner, keys without ExpiresAt do not expire.
+type CreateAPIKeyRequest struct {
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ListAPIKeysRequest struct{}
+
+type RotateAPIKeyRequest struct {
+	ID string
+}
+
+type RevokeAPIKeyRequest struct {
+	ID string
+}
@@ -0,0 +1,30 @@
+package data
+
+import (
+	"api-gateway"
+	"net/http"
+)
+
+// APIKeyResponse describes a key, Key is only set when the key was created or rotated.
+type APIKeyResponse struct {
+	api_gateway.APIKey
+	Key string `json:"key,omitempty"`
+
+	created bool
+}
+
+func NewCreatedAPIKeyResponse(key api_gateway.APIKey, secret string) APIKeyResponse {
+	return APIKeyResponse{APIKey: key, Key: secret, created: true}
+}
+
+func (r APIKeyResponse) StatusCode() int {
+	if r.created {
+		return http.StatusCreated
+	}
+
+	return http.StatusOK
+}
+
+type ListAPIKeysResponse struct {
+	Keys []api_gateway.APIKey `json:"keys"`
+}
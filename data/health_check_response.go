@@ -1,6 +1,36 @@
 package data
 
+import (
+	"net/http"
+	"time"
+)
+
 //Health Response
 type HealthResponse struct {
-	Status bool `json:"status"`
+	Status    bool             `json:"status"`
+	Upstreams []UpstreamHealth `json:"upstreams,omitempty"`
+}
+
+// StatusCode answers unhealthy checks with 503, so that consul notices them.
+func (r HealthResponse) StatusCode() int {
+	if !r.Status {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusOK
+}
+
+// UpstreamHealth is the state of an actively checked upstream, it is healthy while any of its instances is.
+type UpstreamHealth struct {
+	Name      string           `json:"name"`
+	Status    bool             `json:"status"`
+	Instances []InstanceHealth `json:"instances"`
+}
+
+// InstanceHealth is the result of the last health checks of an upstream instance.
+type InstanceHealth struct {
+	Address   string    `json:"address"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
 }
@@ -0,0 +1,8 @@
+package data
+
+// IntrospectionRequest asks whether Token is active, TokenTypeHint tells what kind
+// of token it probably is as described by RFC 7662.
+type IntrospectionRequest struct {
+	Token         string
+	TokenTypeHint string
+}
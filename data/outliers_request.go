@@ -0,0 +1,3 @@
+package data
+
+type OutliersRequest struct{}
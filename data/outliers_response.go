@@ -0,0 +1,17 @@
+package data
+
+import "time"
+
+// EjectedInstance is an upstream instance taken out of balancing by outlier detection.
+type EjectedInstance struct {
+	Upstream  string    `json:"upstream"`
+	Instance  string    `json:"instance"`
+	Reason    string    `json:"reason"`
+	Ejections int       `json:"ejections"`
+	Until     time.Time `json:"until"`
+}
+
+// OutliersResponse lists the ejected instances of all upstreams.
+type OutliersResponse struct {
+	Ejected []EjectedInstance `json:"ejected"`
+}
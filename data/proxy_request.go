@@ -0,0 +1,22 @@
+package data
+
+import (
+	"io"
+	"net/http"
+)
+
+// ProxyRequest carries an incoming request that is forwarded to an upstream unchanged.
+// RawPath is the encoded Path when it differs from the default encoding, as in url.URL.
+// Body is streamed and never buffered by the gateway.
+type ProxyRequest struct {
+	Method        string
+	Path          string
+	RawPath       string
+	RawQuery      string
+	Header        http.Header
+	Body          io.ReadCloser
+	ContentLength int64
+	Host          string
+	RemoteAddr    string
+	Proto         string
+}
@@ -0,0 +1,14 @@
+package data
+
+import (
+	"io"
+	"net/http"
+)
+
+// ProxyResponse is an upstream response streamed back to the client,
+// Body must be closed by whoever consumes it.
+type ProxyResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       io.ReadCloser
+}
@@ -0,0 +1,11 @@
+package data
+
+import "net/http"
+
+// StaticResponse is a fixed reply configured for a route, it is written as is
+// whatever the route handler encodes otherwise.
+type StaticResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
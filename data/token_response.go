@@ -9,8 +9,14 @@ type IssueTokenResponse struct {
 	TokenResponse
 }
 
+// VerifyTokenResponse times are in seconds since the epoch.
 type VerifyTokenResponse struct {
 	TokenResponse
+	Subject   string   `json:"subject,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	IssuedAt  int64    `json:"issued_at,omitempty"`
+	ExpiresAt int64    `json:"expires_at,omitempty"`
 }
 
 type RevokeTokenResponse struct {
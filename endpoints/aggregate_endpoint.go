@@ -0,0 +1,354 @@
+package endpoints
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const queryReference = "query"
+
+var referencePattern = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// AggregateCall is one of the upstream calls an aggregate endpoint fans out to.
+// Endpoint must be a passthrough endpoint of the call upstream.
+type AggregateCall struct {
+	Name     string
+	Method   string
+	Path     string
+	Timeout  time.Duration
+	Optional bool
+	Endpoint endpoint.Endpoint
+
+	dependencies []string
+}
+
+// AggregateError is returned when a required call of an aggregate endpoint fails.
+type AggregateError struct {
+	Call string
+	Err  error
+}
+
+func (e AggregateError) Error() string {
+	return fmt.Sprintf("call %s: %s", e.Call, e.Err)
+}
+
+func (e AggregateError) StatusCode() int {
+	return http.StatusBadGateway
+}
+
+// MakeAggregateEndpoint makes an endpoint that runs the calls concurrently, every call
+// starts as soon as the calls it refers to are done, and merges their JSON responses.
+func MakeAggregateEndpoint(calls []AggregateCall) (endpoint.Endpoint, error) {
+	if err := resolveDependencies(calls); err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		aggregateRequest := request.(AggregateRequest)
+
+		var (
+			mtx       sync.Mutex
+			wg        sync.WaitGroup
+			results   = make(map[string]interface{}, len(calls))
+			failures  = make(map[string]string)
+			failed    error
+			completed = make(map[string]chan struct{}, len(calls))
+		)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		for _, call := range calls {
+			completed[call.Name] = make(chan struct{})
+		}
+
+		for _, call := range calls {
+			wg.Add(1)
+
+			go func(call AggregateCall) {
+				defer wg.Done()
+				defer close(completed[call.Name])
+
+				result, err := runCall(ctx, call, aggregateRequest, completed, func(name string) (interface{}, bool) {
+					mtx.Lock()
+					defer mtx.Unlock()
+					result, ok := results[name]
+
+					return result, ok
+				})
+
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				if err == nil {
+					results[call.Name] = result
+					return
+				}
+
+				failures[call.Name] = err.Error()
+
+				if !call.Optional && failed == nil {
+					failed = AggregateError{call.Name, err}
+					// The route fails anyway, there is no point to wait for other calls
+					cancel()
+				}
+			}(call)
+		}
+
+		wg.Wait()
+
+		if failed != nil {
+			return nil, failed
+		}
+
+		response := AggregateResponse(results)
+
+		if len(failures) > 0 {
+			response[AggregateErrorsKey] = failures
+		}
+
+		return response, nil
+	}, nil
+}
+
+func runCall(ctx context.Context, call AggregateCall, request AggregateRequest,
+	completed map[string]chan struct{}, result func(string) (interface{}, bool)) (interface{}, error) {
+	for _, dependency := range call.dependencies {
+		select {
+		case <-completed[dependency]:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if _, ok := result(dependency); !ok {
+			return nil, errors.New(fmt.Sprintf("call %s it depends on failed", dependency))
+		}
+	}
+
+	target, err := render(call.Path, request.Query, result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if call.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.Timeout)
+		defer cancel()
+	}
+
+	response, err := call.Endpoint(ctx, ProxyRequest{
+		Method:   call.Method,
+		Path:     target.Path,
+		RawPath:  target.RawPath,
+		RawQuery: target.RawQuery,
+		Header:   request.Header,
+		Body:     http.NoBody,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	proxyResponse := response.(ProxyResponse)
+	defer proxyResponse.Body.Close()
+
+	if proxyResponse.StatusCode >= http.StatusBadRequest {
+		return nil, errors.New(fmt.Sprintf("upstream replied %d", proxyResponse.StatusCode))
+	}
+
+	var body interface{}
+	decoder := json.NewDecoder(proxyResponse.Body)
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&body); err != nil {
+		return nil, errors.Wrap(err, "decode response")
+	}
+
+	return body, nil
+}
+
+// render substitutes references in a call path, values in the path
+// and in the query string are escaped accordingly. The escaped path is kept
+// in RawPath so that a value can not add path segments.
+func render(template string, query url.Values, result func(string) (interface{}, bool)) (*url.URL, error) {
+	var renderErr error
+
+	substitute := func(escape func(string) (string, error)) func(string) string {
+		return func(reference string) string {
+			name := reference[1 : len(reference)-1]
+			value, err := lookup(name, query, result)
+
+			if err == nil {
+				value, err = escape(value)
+				err = errors.Wrap(err, name)
+			}
+
+			if err != nil && renderErr == nil {
+				renderErr = err
+			}
+
+			return value
+		}
+	}
+
+	rawPath, rawQuery := template, ""
+
+	if i := strings.Index(template, "?"); i >= 0 {
+		rawPath, rawQuery = template[:i], template[i+1:]
+	}
+
+	target := &url.URL{
+		RawPath:  referencePattern.ReplaceAllStringFunc(rawPath, substitute(escapeSegment)),
+		RawQuery: referencePattern.ReplaceAllStringFunc(rawQuery, substitute(escapeQuery)),
+	}
+
+	if renderErr != nil {
+		return nil, renderErr
+	}
+
+	path, err := url.PathUnescape(target.RawPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target.Path = path
+
+	return target, nil
+}
+
+// escapeSegment rejects dot segments which upstreams resolve even when escaped.
+func escapeSegment(value string) (string, error) {
+	if value == "." || value == ".." {
+		return "", errors.New(fmt.Sprintf("%q is not a path segment", value))
+	}
+
+	return url.PathEscape(value), nil
+}
+
+func escapeQuery(value string) (string, error) {
+	return url.QueryEscape(value), nil
+}
+
+func lookup(reference string, query url.Values, result func(string) (interface{}, bool)) (string, error) {
+	fields := strings.Split(reference, ".")
+
+	if fields[0] == queryReference {
+		return query.Get(strings.Join(fields[1:], ".")), nil
+	}
+
+	value, _ := result(fields[0])
+
+	for _, field := range fields[1:] {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[field]
+		case []interface{}:
+			i, err := strconv.Atoi(field)
+
+			if err != nil || i < 0 || i >= len(v) {
+				return "", errors.New(fmt.Sprintf("no %s in response", reference))
+			}
+
+			value = v[i]
+		default:
+			return "", errors.New(fmt.Sprintf("no %s in response", reference))
+		}
+	}
+
+	switch value.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return "", errors.New(fmt.Sprintf("%s is not a scalar", reference))
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+// resolveDependencies finds the calls every call refers to and rejects
+// unknown references and cycles.
+func resolveDependencies(calls []AggregateCall) error {
+	names := make(map[string]int, len(calls))
+
+	for i, call := range calls {
+		if len(call.Name) == 0 || call.Name == queryReference || call.Name == AggregateErrorsKey {
+			return errors.New(fmt.Sprintf("invalid call name %q", call.Name))
+		}
+
+		if _, ok := names[call.Name]; ok {
+			return errors.New(fmt.Sprintf("duplicate call %s", call.Name))
+		}
+
+		names[call.Name] = i
+	}
+
+	for i := range calls {
+		calls[i].dependencies = nil
+
+		for _, match := range referencePattern.FindAllStringSubmatch(calls[i].Path, -1) {
+			name := strings.Split(match[1], ".")[0]
+
+			if name == queryReference {
+				continue
+			}
+
+			if _, ok := names[name]; !ok {
+				return errors.New(fmt.Sprintf("call %s refers to unknown call %s", calls[i].Name, name))
+			}
+
+			calls[i].dependencies = append(calls[i].dependencies, name)
+		}
+	}
+
+	// Depth first search for cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(calls))
+
+	var visit func(i int) error
+
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return errors.New(fmt.Sprintf("call %s depends on itself", calls[i].Name))
+		case visited:
+			return nil
+		}
+
+		state[i] = visiting
+
+		for _, dependency := range calls[i].dependencies {
+			if err := visit(names[dependency]); err != nil {
+				return err
+			}
+		}
+
+		state[i] = visited
+
+		return nil
+	}
+
+	for i := range calls {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
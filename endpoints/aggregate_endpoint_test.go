@@ -0,0 +1,145 @@
+package endpoints
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func results(values map[string]interface{}) func(string) (interface{}, bool) {
+	return func(name string) (interface{}, bool) {
+		value, ok := values[name]
+
+		return value, ok
+	}
+}
+
+func TestRenderEscapesValues(t *testing.T) {
+	query := url.Values{"id": {"a/b"}, "q": {"x&y=z#f"}}
+	result := results(map[string]interface{}{
+		"user": map[string]interface{}{"name": "what?", "tag": "#1", "team": "a/../b"},
+	})
+
+	tests := []struct {
+		template string
+		path     string
+		rawPath  string
+		rawQuery string
+	}{
+		{"/users/{query.id}/orders", "/users/a/b/orders", "/users/a%2Fb/orders", ""},
+		{"/users/{user.name}", "/users/what?", "/users/what%3F", ""},
+		{"/tags/{user.tag}", "/tags/#1", "/tags/%231", ""},
+		{"/teams/{user.team}", "/teams/a/../b", "/teams/a%2F..%2Fb", ""},
+		{"/search?q={query.q}&id={query.id}", "/search", "/search", "q=x%26y%3Dz%23f&id=a%2Fb"},
+	}
+
+	for _, test := range tests {
+		target, err := render(test.template, query, result)
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.template, err)
+		}
+
+		if target.Path != test.path || target.RawPath != test.rawPath || target.RawQuery != test.rawQuery {
+			t.Errorf("%s: rendered path %q, raw path %q, query %q", test.template, target.Path, target.RawPath, target.RawQuery)
+		}
+
+		if target.EscapedPath() != test.rawPath {
+			t.Errorf("%s: escaped path %q, want %q", test.template, target.EscapedPath(), test.rawPath)
+		}
+	}
+}
+
+func TestRenderRejectsDotSegments(t *testing.T) {
+	for _, value := range []string{".", ".."} {
+		query := url.Values{"id": {value}}
+
+		if _, err := render("/users/{query.id}/orders", query, results(nil)); err == nil {
+			t.Errorf("%q is rendered as a path segment", value)
+		}
+
+		if _, err := render("/users?id={query.id}", query, results(nil)); err != nil {
+			t.Errorf("%q is rejected in the query: %v", value, err)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	result := results(map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":     json.Number("42"),
+			"active": true,
+			"roles":  []interface{}{"admin", "dev"},
+			"team":   map[string]interface{}{"name": "core"},
+			"none":   nil,
+		},
+	})
+	query := url.Values{"page.size": {"10"}}
+
+	valid := map[string]string{
+		"user.id":         "42",
+		"user.active":     "true",
+		"user.roles.1":    "dev",
+		"user.team.name":  "core",
+		"query.page.size": "10",
+		"query.missing":   "",
+	}
+
+	for reference, want := range valid {
+		value, err := lookup(reference, query, result)
+
+		if err != nil || value != want {
+			t.Errorf("%s: got %q, %v, want %q", reference, value, err, want)
+		}
+	}
+
+	invalid := []string{"user", "user.roles", "user.team", "user.none", "user.roles.2", "user.roles.x",
+		"user.id.x", "user.missing", "other.id"}
+
+	for _, reference := range invalid {
+		if value, err := lookup(reference, query, result); err == nil {
+			t.Errorf("%s: got %q", reference, value)
+		}
+	}
+}
+
+func TestAggregateCallKeepsEscapedPath(t *testing.T) {
+	received := make(chan string, 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.RequestURI
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	target, _ := url.Parse(upstream.URL)
+
+	aggregate, err := MakeAggregateEndpoint([]AggregateCall{{
+		Name:     "files",
+		Method:   http.MethodGet,
+		Path:     "/files/{query.name}",
+		Endpoint: MakePassthroughEndpoint(target, upstream.Client()),
+	}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = aggregate(context.Background(), AggregateRequest{
+		Query:  url.Values{"name": {"../admin?x#y"}},
+		Header: http.Header{},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri, want := <-received, "/files/..%2Fadmin%3Fx%23y"; uri != want {
+		t.Errorf("upstream got %q, want %q", uri, want)
+	}
+}
@@ -0,0 +1,63 @@
+package endpoints
+
+import (
+	"api-gateway"
+	. "api-gateway/data"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+)
+
+func MakeCreateAPIKeyEndpoint(service api_gateway.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		createRequest := request.(CreateAPIKeyRequest)
+
+		key, secret, err := service.CreateKey(ctx, api_gateway.APIKey{
+			Owner:     createRequest.Owner,
+			Scopes:    createRequest.Scopes,
+			ExpiresAt: createRequest.ExpiresAt,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return NewCreatedAPIKeyResponse(key, secret), nil
+	}
+}
+
+func MakeListAPIKeysEndpoint(service api_gateway.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		keys, err := service.ListKeys(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return ListAPIKeysResponse{Keys: keys}, nil
+	}
+}
+
+func MakeRotateAPIKeyEndpoint(service api_gateway.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		key, secret, err := service.RotateKey(ctx, request.(RotateAPIKeyRequest).ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return APIKeyResponse{APIKey: key, Key: secret}, nil
+	}
+}
+
+func MakeRevokeAPIKeyEndpoint(service api_gateway.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		key, err := service.RevokeKey(ctx, request.(RevokeAPIKeyRequest).ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return APIKeyResponse{APIKey: key}, nil
+	}
+}
@@ -0,0 +1,34 @@
+package endpoints
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DeadlineHeader tells upstreams how many milliseconds are left before the gateway gives up.
+const DeadlineHeader = "X-Request-Timeout-Ms"
+
+func setDeadlineHeader(ctx context.Context, header http.Header) {
+	deadline, ok := ctx.Deadline()
+
+	if !ok {
+		return
+	}
+
+	remaining := time.Until(deadline) / time.Millisecond
+
+	if remaining < 1 {
+		remaining = 1
+	}
+
+	header.Set(DeadlineHeader, strconv.FormatInt(int64(remaining), 10))
+}
+
+// propagateDeadline is a client request func passing the request deadline on.
+func propagateDeadline(ctx context.Context, r *http.Request) context.Context {
+	setDeadlineHeader(ctx, r.Header)
+
+	return ctx
+}
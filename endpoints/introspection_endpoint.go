@@ -0,0 +1,102 @@
+package endpoints
+
+import (
+	"api-gateway"
+	. "api-gateway/data"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIKeyTypeHint is a token type hint only known to the gateway, API keys are looked up first.
+const APIKeyTypeHint = "api_key"
+
+// VerificationError is returned when a token can not be told active or not, it is reported as 503.
+type VerificationError struct {
+	Err error
+}
+
+func (e VerificationError) Error() string {
+	return "token can not be verified: " + e.Err.Error()
+}
+
+func (e VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func (e VerificationError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// MakeIntrospectionEndpoint describes tokens verified by the token service and, when apiKeys
+// is not nil, API keys. Tokens are looked up as the hinted type first, then as the other one.
+func MakeIntrospectionEndpoint(tokenService api_gateway.TokenService, apiKeys api_gateway.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		introspectionRequest := request.(IntrospectionRequest)
+
+		type verifier struct {
+			tokenType string
+			verify    func(context.Context, string) (api_gateway.Identity, error)
+		}
+
+		verifiers := []verifier{{"Bearer", tokenService.VerifyToken}}
+
+		if apiKeys != nil {
+			key := verifier{APIKeyTypeHint, apiKeys.VerifyKey}
+
+			if introspectionRequest.TokenTypeHint == APIKeyTypeHint {
+				verifiers = []verifier{key, verifiers[0]}
+			} else {
+				verifiers = append(verifiers, key)
+			}
+		}
+
+		for _, v := range verifiers {
+			identity, err := v.verify(ctx, introspectionRequest.Token)
+
+			if err != nil {
+				var invalid api_gateway.InvalidTokenError
+
+				if !errors.As(err, &invalid) {
+					return nil, VerificationError{err}
+				}
+
+				continue
+			}
+
+			if !identity.ExpiresAt.IsZero() && !time.Now().Before(identity.ExpiresAt) {
+				continue
+			}
+
+			return introspection(identity, v.tokenType), nil
+		}
+
+		return IntrospectionResponse{Active: false}, nil
+	}
+}
+
+func introspection(identity api_gateway.Identity, tokenType string) IntrospectionResponse {
+	response := IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(identity.Scopes, " "),
+		ClientID:  identity.ClientID,
+		TokenType: tokenType,
+		Subject:   identity.Subject,
+		Audience:  identity.Audiences,
+		Issuer:    identity.Issuer,
+	}
+
+	if !identity.ExpiresAt.IsZero() {
+		response.ExpiresAt = identity.ExpiresAt.Unix()
+	}
+
+	if !identity.IssuedAt.IsZero() {
+		response.IssuedAt = identity.IssuedAt.Unix()
+	}
+
+	return response
+}
@@ -0,0 +1,152 @@
+package endpoints
+
+import (
+	"api-gateway"
+	. "api-gateway/data"
+
+	"context"
+	"github.com/pkg/errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// verifications answers tokens from a map and records which verifier was asked.
+type verifications struct {
+	name       string
+	identities map[string]api_gateway.Identity
+	outage     bool
+	calls      *[]string
+}
+
+func (v verifications) verify(_ context.Context, token string) (api_gateway.Identity, error) {
+	*v.calls = append(*v.calls, v.name)
+
+	if v.outage {
+		return api_gateway.Identity{}, errors.New("connection refused")
+	}
+
+	identity, ok := v.identities[token]
+
+	if !ok {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: "unknown"}
+	}
+
+	return identity, nil
+}
+
+type introspectionTokens struct {
+	api_gateway.TokenService
+	verifications
+}
+
+func (s introspectionTokens) VerifyToken(ctx context.Context, token string) (api_gateway.Identity, error) {
+	return s.verify(ctx, token)
+}
+
+type introspectionKeys struct {
+	api_gateway.APIKeyService
+	verifications
+}
+
+func (s introspectionKeys) VerifyKey(ctx context.Context, key string) (api_gateway.Identity, error) {
+	return s.verify(ctx, key)
+}
+
+func newIntrospectionEndpoint(calls *[]string, withKeys, outage bool) func(token, hint string) (interface{}, error) {
+	issuedAt := time.Unix(1700000000, 0)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tokens := introspectionTokens{verifications: verifications{"token", map[string]api_gateway.Identity{
+		"reader": {Subject: "alice", Scopes: []string{"read", "write"}, Issuer: "https://issuer",
+			Audiences: []string{"api"}, IssuedAt: issuedAt, ExpiresAt: expiresAt},
+		"expired": {Subject: "bob", ExpiresAt: time.Now().Add(-time.Minute)},
+	}, outage, calls}}
+
+	var keys api_gateway.APIKeyService
+
+	if withKeys {
+		keys = introspectionKeys{verifications: verifications{"key", map[string]api_gateway.Identity{
+			"k1.secret": {Subject: "billing", ClientID: "k1", Scopes: []string{"read"}},
+		}, false, calls}}
+	}
+
+	e := MakeIntrospectionEndpoint(tokens, keys)
+
+	return func(token, hint string) (interface{}, error) {
+		*calls = nil
+
+		return e(context.Background(), IntrospectionRequest{Token: token, TokenTypeHint: hint})
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	var calls []string
+	introspect := newIntrospectionEndpoint(&calls, true, false)
+
+	tests := []struct {
+		token, hint string
+		want        IntrospectionResponse
+		calls       []string
+	}{
+		{"reader", "", IntrospectionResponse{Active: true, Scope: "read write", TokenType: "Bearer", Subject: "alice",
+			Audience: []string{"api"}, Issuer: "https://issuer", IssuedAt: 1700000000}, []string{"token"}},
+		{"k1.secret", "", IntrospectionResponse{Active: true, Scope: "read", ClientID: "k1", TokenType: APIKeyTypeHint,
+			Subject: "billing"}, []string{"token", "key"}},
+		{"k1.secret", APIKeyTypeHint, IntrospectionResponse{Active: true, Scope: "read", ClientID: "k1",
+			TokenType: APIKeyTypeHint, Subject: "billing"}, []string{"key"}},
+		{"reader", APIKeyTypeHint, IntrospectionResponse{Active: true, Scope: "read write", TokenType: "Bearer",
+			Subject: "alice", Audience: []string{"api"}, Issuer: "https://issuer", IssuedAt: 1700000000}, []string{"key", "token"}},
+		{"expired", "", IntrospectionResponse{Active: false}, []string{"token", "key"}},
+		{"unknown", "access_token", IntrospectionResponse{Active: false}, []string{"token", "key"}},
+	}
+
+	for _, test := range tests {
+		response, err := introspect(test.token, test.hint)
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.token, err)
+		}
+
+		got := response.(IntrospectionResponse)
+
+		// The expiration time is relative to now
+		if got.Active && test.want.TokenType == "Bearer" {
+			if got.ExpiresAt <= time.Now().Unix() {
+				t.Errorf("%s: exp %d", test.token, got.ExpiresAt)
+			}
+
+			got.ExpiresAt = 0
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s hinted %q: got %+v, want %+v", test.token, test.hint, got, test.want)
+		}
+
+		if !reflect.DeepEqual(calls, test.calls) {
+			t.Errorf("%s hinted %q: verified by %v, want %v", test.token, test.hint, calls, test.calls)
+		}
+	}
+}
+
+func TestIntrospectionWithoutAPIKeys(t *testing.T) {
+	var calls []string
+	introspect := newIntrospectionEndpoint(&calls, false, false)
+
+	response, err := introspect("k1.secret", APIKeyTypeHint)
+
+	if err != nil || response.(IntrospectionResponse).Active || !reflect.DeepEqual(calls, []string{"token"}) {
+		t.Errorf("got %+v, %v, verified by %v", response, err, calls)
+	}
+}
+
+func TestIntrospectionFailsWhenTokensCanNotBeVerified(t *testing.T) {
+	var calls []string
+	introspect := newIntrospectionEndpoint(&calls, true, true)
+
+	_, err := introspect("reader", "")
+
+	if _, ok := err.(VerificationError); !ok {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package endpoints
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+)
+
+// MakeOutliersEndpoint reports the upstream instances listed by ejections.
+func MakeOutliersEndpoint(ejections func() []EjectedInstance) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return OutliersResponse{
+			Ejected: ejections(),
+		}, nil
+	}
+}
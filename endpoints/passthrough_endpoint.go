@@ -0,0 +1,111 @@
+package endpoints
+
+import (
+	. "api-gateway/data"
+	"api-gateway/middleware"
+	"api-gateway/transports"
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// UpstreamError is returned when the upstream could not be reached, it is reported as 502.
+type UpstreamError struct {
+	Err error
+}
+
+func (e UpstreamError) Error() string {
+	return "upstream error: " + e.Err.Error()
+}
+
+func (e UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+func (e UpstreamError) StatusCode() int {
+	return http.StatusBadGateway
+}
+
+// MakePassthroughEndpoint forwards ProxyRequest to the target as is and returns
+// the upstream response with a body that is not read yet.
+func MakePassthroughEndpoint(target *url.URL, client *http.Client) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		proxyRequest := request.(ProxyRequest)
+
+		upstreamURL := *target
+		upstreamURL.Path = joinPath(target.Path, proxyRequest.Path)
+		upstreamURL.RawPath = ""
+		upstreamURL.RawQuery = proxyRequest.RawQuery
+
+		// Encoded characters such as %2F stay encoded
+		if len(proxyRequest.RawPath) > 0 {
+			upstreamURL.RawPath = joinPath(target.EscapedPath(), proxyRequest.RawPath)
+		}
+
+		body := proxyRequest.Body
+
+		if proxyRequest.ContentLength == 0 {
+			body = http.NoBody
+		}
+
+		req, err := http.NewRequest(proxyRequest.Method, upstreamURL.String(), body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req = req.WithContext(ctx)
+		req.ContentLength = proxyRequest.ContentLength
+
+		for name, values := range proxyRequest.Header {
+			req.Header[name] = append([]string(nil), values...)
+		}
+
+		setForwardedHeaders(req.Header, proxyRequest)
+		setDeadlineHeader(ctx, req.Header)
+
+		resp, err := client.Do(req)
+
+		if err != nil && middleware.IsTimeout(err) {
+			return nil, middleware.GatewayTimeoutError{Err: err}
+		}
+
+		if err != nil {
+			return nil, UpstreamError{err}
+		}
+
+		transports.RemoveHopHeaders(resp.Header)
+
+		return ProxyResponse{
+			StatusCode: resp.StatusCode,
+			Header:     resp.Header,
+			Body:       resp.Body,
+		}, nil
+	}
+}
+
+func setForwardedHeaders(header http.Header, proxyRequest ProxyRequest) {
+	if clientIP, _, err := net.SplitHostPort(proxyRequest.RemoteAddr); err == nil {
+		if prior := header.Get("X-Forwarded-For"); len(prior) > 0 {
+			clientIP = prior + ", " + clientIP
+		}
+
+		header.Set("X-Forwarded-For", clientIP)
+	}
+
+	header.Set("X-Forwarded-Host", proxyRequest.Host)
+	header.Set("X-Forwarded-Proto", proxyRequest.Proto)
+}
+
+// joinPath puts requestPath under base as it is, dot segments of clients are not resolved
+// so that they can not climb out of base.
+func joinPath(base, requestPath string) string {
+	if len(base) == 0 || base == "/" {
+		return requestPath
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(requestPath, "/")
+}
@@ -0,0 +1,57 @@
+package endpoints
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPassthroughKeepsRequestPath(t *testing.T) {
+	received := make(chan string, 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.RequestURI
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		base    string
+		path    string
+		rawPath string
+		query   string
+		want    string
+	}{
+		{"", "/files/a/b/raw", "/files/a%2Fb/raw", "", "/files/a%2Fb/raw"},
+		{"/v2", "/files/a/b/raw", "/files/a%2Fb/raw", "x=1", "/v2/files/a%2Fb/raw?x=1"},
+		{"/v2/", "/files/", "", "", "/v2/files/"},
+		{"/v2", "/files/../admin", "", "", "/v2/files/../admin"},
+		{"/v2", "/files/a b", "", "", "/v2/files/a%20b"},
+	}
+
+	for _, test := range tests {
+		target, _ := url.Parse(upstream.URL + test.base)
+		passthrough := MakePassthroughEndpoint(target, upstream.Client())
+
+		response, err := passthrough(context.Background(), ProxyRequest{
+			Method:   http.MethodGet,
+			Path:     test.path,
+			RawPath:  test.rawPath,
+			RawQuery: test.query,
+			Header:   http.Header{},
+		})
+
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+
+		response.(ProxyResponse).Body.Close()
+
+		if uri := <-received; uri != test.want {
+			t.Errorf("%s under %q: upstream got %q, want %q", test.path, test.base, uri, test.want)
+		}
+	}
+}
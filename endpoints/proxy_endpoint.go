@@ -3,6 +3,7 @@ package endpoints
 import (
 	"api-gateway"
 	. "api-gateway/data"
+	"api-gateway/middleware"
 	"api-gateway/transports"
 	"context"
 	"github.com/go-kit/kit/endpoint"
@@ -11,31 +12,51 @@ import (
 	"net/url"
 )
 
-func MakeProxyIssueTokenEndpoint(proxyURL *url.URL) endpoint.Endpoint {
+func MakeProxyIssueTokenEndpoint(proxyURL *url.URL, client *http.Client) endpoint.Endpoint {
 	return httptransport.NewClient(http.MethodPost,
 		proxyURL,
 		httptransport.EncodeJSONRequest,
-		transports.DecodeIssueTokenResponse).Endpoint()
+		transports.DecodeIssueTokenResponse,
+		httptransport.SetClient(client),
+		httptransport.ClientBefore(forwardIdempotencyKey, propagateDeadline)).Endpoint()
 }
 
-func MakeProxyVerifyTokenEndpoint(proxyURL *url.URL) endpoint.Endpoint {
+func MakeProxyVerifyTokenEndpoint(proxyURL *url.URL, client *http.Client) endpoint.Endpoint {
 	return httptransport.NewClient(http.MethodPost,
 		proxyURL,
 		httptransport.EncodeJSONRequest,
-		transports.DecodeVerifyTokenResponse).Endpoint()
+		transports.DecodeVerifyTokenResponse,
+		httptransport.SetClient(client),
+		httptransport.ClientBefore(forwardIdempotencyKey, propagateDeadline)).Endpoint()
 }
 
-func MakeProxyRevokeTokenEndpoint(proxyURL *url.URL) endpoint.Endpoint {
+func MakeProxyRevokeTokenEndpoint(proxyURL *url.URL, client *http.Client) endpoint.Endpoint {
 	return httptransport.NewClient(http.MethodPost,
 		proxyURL,
 		httptransport.EncodeJSONRequest,
-		transports.DecodeRevokeTokenResponse).Endpoint()
+		transports.DecodeRevokeTokenResponse,
+		httptransport.SetClient(client),
+		httptransport.ClientBefore(forwardIdempotencyKey, propagateDeadline)).Endpoint()
 }
 
-func MakeHealthCheckEndpoint(service api_gateway.TokenService) endpoint.Endpoint {
+// MakeHealthCheckEndpoint reports the service healthy while its token service is. Upstreams
+// listed by health are reported one by one and do not fail the check, as consul would
+// otherwise take the gateway out of service for all routes when one upstream is down.
+func MakeHealthCheckEndpoint(service api_gateway.TokenService, health func() []UpstreamHealth) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		return HealthResponse{
-			service.HealthCheck(),
+			Status:    service.HealthCheck(),
+			Upstreams: health(),
 		}, nil
 	}
 }
+
+// forwardIdempotencyKey passes the idempotency key of the client on, so that the
+// upstream can tell a retried request from a new one.
+func forwardIdempotencyKey(ctx context.Context, r *http.Request) context.Context {
+	if key := middleware.IdempotencyKey(ctx); len(key) > 0 {
+		r.Header.Set(middleware.IdempotencyKeyHeader, key)
+	}
+
+	return ctx
+}
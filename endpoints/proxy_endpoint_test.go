@@ -0,0 +1,48 @@
+package endpoints
+
+import (
+	"api-gateway"
+	. "api-gateway/data"
+
+	"context"
+	"net/http"
+	"testing"
+)
+
+type healthTokenService struct {
+	api_gateway.TokenService
+	healthy bool
+}
+
+func (s healthTokenService) HealthCheck() bool {
+	return s.healthy
+}
+
+func TestHealthCheckFollowsTheTokenService(t *testing.T) {
+	upstreams := []UpstreamHealth{
+		{Name: "orders", Status: true},
+		{Name: "reports", Status: false},
+	}
+
+	for _, healthy := range []bool{true, false} {
+		e := MakeHealthCheckEndpoint(healthTokenService{healthy: healthy}, func() []UpstreamHealth {
+			return upstreams
+		})
+
+		response, err := e(context.Background(), HealthRequest{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		health := response.(HealthResponse)
+
+		if health.Status != healthy || len(health.Upstreams) != 2 || health.Upstreams[1].Status {
+			t.Errorf("token service healthy %v: got %+v", healthy, health)
+		}
+
+		if want := map[bool]int{true: http.StatusOK, false: http.StatusServiceUnavailable}[healthy]; health.StatusCode() != want {
+			t.Errorf("token service healthy %v: status code %d", healthy, health.StatusCode())
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// limitSmoothing is the weight of a new estimate in the concurrency limit
+	limitSmoothing = 0.2
+	// rttWeight is the weight of the last call in the long term round trip time
+	rttWeight = 0.05
+	// minGradient bounds how much one slow call can lower the limit
+	minGradient = 0.5
+)
+
+// LimitExceededError is returned when an adaptive limiter has no room for another call.
+type LimitExceededError struct {
+	Name  string
+	Limit int
+}
+
+func (e LimitExceededError) Error() string {
+	return fmt.Sprintf("concurrency limit %d of %s exceeded", e.Limit, e.Name)
+}
+
+func (e LimitExceededError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e LimitExceededError) Headers() http.Header {
+	return http.Header{"Retry-After": []string{"1"}}
+}
+
+// AdaptiveLimiter limits concurrent calls to a limit that follows the observed
+// round trip times and failures. The limit grows while calls are as fast as the
+// long term average allowing for Tolerance, shrinks with the ratio of the two
+// as calls slow down and is multiplied by Backoff on every failure.
+type AdaptiveLimiter struct {
+	Name      string
+	MinLimit  float64
+	MaxLimit  float64
+	Tolerance float64
+	Backoff   float64
+
+	limitGauge metrics.Gauge
+	limited    metrics.Counter
+
+	mtx      sync.Mutex
+	limit    float64
+	inFlight int
+	longRTT  float64
+}
+
+func NewAdaptiveLimiter(name string, initialLimit, minLimit, maxLimit int, tolerance, backoff float64,
+	limitGauge metrics.Gauge, limited metrics.Counter) *AdaptiveLimiter {
+	limitGauge.Set(float64(initialLimit))
+
+	return &AdaptiveLimiter{
+		Name:       name,
+		MinLimit:   float64(minLimit),
+		MaxLimit:   float64(maxLimit),
+		Tolerance:  tolerance,
+		Backoff:    backoff,
+		limitGauge: limitGauge,
+		limited:    limited,
+		limit:      float64(initialLimit),
+	}
+}
+
+func (limiter *AdaptiveLimiter) acquire() error {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	if limiter.inFlight >= int(limiter.limit) {
+		limiter.limited.Add(1)
+		return LimitExceededError{limiter.Name, int(limiter.limit)}
+	}
+
+	limiter.inFlight++
+
+	return nil
+}
+
+// release ends a call that took rtt, unmeasured calls only free their slot.
+func (limiter *AdaptiveLimiter) release(rtt time.Duration, failed, unmeasured bool) {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	inFlight := limiter.inFlight
+	limiter.inFlight--
+
+	if unmeasured {
+		return
+	}
+
+	limit := limiter.limit
+
+	if failed {
+		limit *= limiter.Backoff
+	} else {
+		sample := rtt.Seconds()
+
+		if limiter.longRTT == 0 {
+			limiter.longRTT = sample
+		} else {
+			limiter.longRTT += rttWeight * (sample - limiter.longRTT)
+		}
+
+		gradient := math.Max(minGradient, math.Min(1, limiter.Tolerance*limiter.longRTT/math.Max(sample, 1e-9)))
+		estimate := limit*gradient + math.Sqrt(limit)
+
+		// The limit grows only while it is actually used
+		if estimate > limit && float64(inFlight) < limit/2 {
+			return
+		}
+
+		limit = limit*(1-limitSmoothing) + estimate*limitSmoothing
+	}
+
+	limiter.limit = math.Max(limiter.MinLimit, math.Min(limiter.MaxLimit, limit))
+	limiter.limitGauge.Set(math.Floor(limiter.limit))
+}
+
+// rejection is implemented by errors of calls rejected before they reached an upstream,
+// such as an open breaker, they tell nothing about its capacity.
+type rejection interface {
+	error
+	Rejected()
+}
+
+// AdaptiveLimitMiddleware runs calls within the limit of the limiter. Errors and responses
+// with server error statuses count as failures, rejections and calls cancelled by clients
+// are not measured. Streamed responses hold their slot until their body is closed.
+func AdaptiveLimitMiddleware(limiter *AdaptiveLimiter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err := limiter.acquire(); err != nil {
+				return nil, err
+			}
+
+			begin := time.Now()
+			response, err := next(ctx, request)
+			rtt := time.Since(begin)
+			var rejected rejection
+			unmeasured := err != nil && (ctx.Err() == context.Canceled || errors.As(err, &rejected))
+
+			if proxyResponse, ok := response.(ProxyResponse); ok && err == nil {
+				failed := proxyResponse.StatusCode >= http.StatusInternalServerError
+				proxyResponse.Body = newOnCloseBody(proxyResponse.Body, func() {
+					limiter.release(rtt, failed, false)
+				})
+
+				return proxyResponse, nil
+			}
+
+			limiter.release(rtt, err != nil, unmeasured)
+
+			return response, err
+		}
+	}
+}
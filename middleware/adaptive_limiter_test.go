@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	discardmetrics "github.com/go-kit/kit/metrics/discard"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+type rejectedError struct{}
+
+func (rejectedError) Error() string {
+	return "rejected"
+}
+
+func (rejectedError) Rejected() {}
+
+func newTestLimiter() *AdaptiveLimiter {
+	return NewAdaptiveLimiter("test", 10, 1, 100, 2, 0.5, discardmetrics.NewGauge(), discardmetrics.NewCounter())
+}
+
+func TestAdaptiveLimiterBacksOffOnFailures(t *testing.T) {
+	limiter := newTestLimiter()
+	e := AdaptiveLimitMiddleware(limiter)(func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	e(context.Background(), nil)
+
+	if limiter.limit != 5 || limiter.inFlight != 0 {
+		t.Errorf("limit %v, in flight %d after a failure", limiter.limit, limiter.inFlight)
+	}
+}
+
+func TestAdaptiveLimiterIgnoresRejections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"open breaker", context.Background(), CircuitOpenError{"test"}},
+		{"wrapped rejection", context.Background(), errors.Wrap(rejectedError{}, "call")},
+		{"cancelled call", ctx, context.Canceled},
+	}
+
+	for _, test := range tests {
+		limiter := newTestLimiter()
+		e := AdaptiveLimitMiddleware(limiter)(func(context.Context, interface{}) (interface{}, error) {
+			return nil, test.err
+		})
+
+		for i := 0; i < 5; i++ {
+			if _, err := e(test.ctx, nil); err != test.err {
+				t.Fatalf("%s: got %v", test.name, err)
+			}
+		}
+
+		if limiter.limit != 10 || limiter.inFlight != 0 {
+			t.Errorf("%s: limit %v, in flight %d", test.name, limiter.limit, limiter.inFlight)
+		}
+	}
+}
+
+func TestAdaptiveLimiterRejectsOverLimit(t *testing.T) {
+	limiter := NewAdaptiveLimiter("test", 1, 1, 100, 2, 0.5, discardmetrics.NewGauge(), discardmetrics.NewCounter())
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	e := AdaptiveLimitMiddleware(limiter)(func(context.Context, interface{}) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+
+	go e(context.Background(), nil)
+	<-started
+
+	if _, err := e(context.Background(), nil); !errors.As(err, &LimitExceededError{}) {
+		t.Errorf("call over the limit got %v", err)
+	}
+
+	close(release)
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+)
+
+// attempts are the upstream instances the attempts of a request were sent to.
+type attempts struct {
+	mtx       sync.Mutex
+	instances map[string]bool
+}
+
+type attemptsKey struct{}
+
+// WithAttempts prepares the request context to remember the instances its attempts go to,
+// so that later attempts can avoid them. Contexts already prepared are returned as is.
+func WithAttempts(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(attemptsKey{}).(*attempts); ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, attemptsKey{}, &attempts{instances: make(map[string]bool)})
+}
+
+// Attempted records that an attempt of the request goes to instance.
+func Attempted(ctx context.Context, instance string) {
+	if a, ok := ctx.Value(attemptsKey{}).(*attempts); ok {
+		a.mtx.Lock()
+		a.instances[instance] = true
+		a.mtx.Unlock()
+	}
+}
+
+// AttemptedInstances reports the instances earlier attempts of the request went to,
+// it returns nil when nothing was attempted or attempts are not remembered.
+func AttemptedInstances(ctx context.Context) map[string]bool {
+	a, ok := ctx.Value(attemptsKey{}).(*attempts)
+
+	if !ok {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(a.instances) == 0 {
+		return nil
+	}
+
+	instances := make(map[string]bool, len(a.instances))
+
+	for instance := range a.instances {
+		instances[instance] = true
+	}
+
+	return instances
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"io"
+	"sync"
+)
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// onCloseBody runs onClose once the response body is closed, streamed responses
+// hold their resources until then.
+type onCloseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	onClose func()
+}
+
+func newOnCloseBody(body io.ReadCloser, onClose func()) *onCloseBody {
+	return &onCloseBody{ReadCloser: body, onClose: onClose}
+}
+
+func (body *onCloseBody) Close() error {
+	defer body.once.Do(body.onClose)
+
+	return body.ReadCloser.Close()
+}
+
+// discard releases a response that is not returned to the client.
+func discard(response interface{}) {
+	if proxyResponse, ok := response.(ProxyResponse); ok && proxyResponse.Body != nil {
+		proxyResponse.Body.Close()
+	}
+}
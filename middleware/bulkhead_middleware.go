@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BulkheadFullError is returned when a bulkhead has neither a free slot nor room in its queue.
+type BulkheadFullError struct {
+	Name       string
+	RetryAfter time.Duration
+}
+
+func (e BulkheadFullError) Error() string {
+	return fmt.Sprintf("too many concurrent requests to %s", e.Name)
+}
+
+func (e BulkheadFullError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e BulkheadFullError) Headers() http.Header {
+	seconds := int((e.RetryAfter + time.Second - 1) / time.Second)
+
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return http.Header{"Retry-After": []string{strconv.Itoa(seconds)}}
+}
+
+// Bulkhead limits the number of concurrent calls, up to MaxQueued calls wait
+// for a free slot at most QueueTimeout and the rest are rejected.
+type Bulkhead struct {
+	Name         string
+	MaxQueued    int
+	QueueTimeout time.Duration
+	RetryAfter   time.Duration
+
+	slots    chan struct{}
+	inFlight metrics.Gauge
+	depth    metrics.Gauge
+
+	mtx    sync.Mutex
+	queued int
+}
+
+func NewBulkhead(name string, maxInFlight, maxQueued int, queueTimeout, retryAfter time.Duration,
+	inFlight, depth metrics.Gauge) *Bulkhead {
+	inFlight.Set(0)
+	depth.Set(0)
+
+	return &Bulkhead{
+		Name:         name,
+		MaxQueued:    maxQueued,
+		QueueTimeout: queueTimeout,
+		RetryAfter:   retryAfter,
+		slots:        make(chan struct{}, maxInFlight),
+		inFlight:     inFlight,
+		depth:        depth,
+	}
+}
+
+func (bulkhead *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case bulkhead.slots <- struct{}{}:
+		bulkhead.inFlight.Add(1)
+		return nil
+	default:
+	}
+
+	bulkhead.mtx.Lock()
+
+	if bulkhead.queued >= bulkhead.MaxQueued {
+		bulkhead.mtx.Unlock()
+		return BulkheadFullError{bulkhead.Name, bulkhead.RetryAfter}
+	}
+
+	bulkhead.queued++
+	bulkhead.depth.Add(1)
+	bulkhead.mtx.Unlock()
+
+	defer func() {
+		bulkhead.mtx.Lock()
+		bulkhead.queued--
+		bulkhead.depth.Add(-1)
+		bulkhead.mtx.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+
+	if bulkhead.QueueTimeout > 0 {
+		timer := time.NewTimer(bulkhead.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case bulkhead.slots <- struct{}{}:
+		bulkhead.inFlight.Add(1)
+		return nil
+	case <-timeout:
+		return BulkheadFullError{bulkhead.Name, bulkhead.RetryAfter}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bulkhead *Bulkhead) release() {
+	<-bulkhead.slots
+	bulkhead.inFlight.Add(-1)
+}
+
+// BulkheadMiddleware runs calls in the slots of the bulkhead,
+// streamed responses hold their slot until their body is closed.
+func BulkheadMiddleware(bulkhead *Bulkhead) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err := bulkhead.acquire(ctx); err != nil {
+				return nil, err
+			}
+
+			response, err := next(ctx, request)
+
+			if proxyResponse, ok := response.(ProxyResponse); ok && err == nil {
+				proxyResponse.Body = newOnCloseBody(proxyResponse.Body, bulkhead.release)
+
+				return proxyResponse, nil
+			}
+
+			bulkhead.release()
+
+			return response, err
+		}
+	}
+}
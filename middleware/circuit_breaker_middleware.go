@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/sony/gobreaker"
+	"net/http"
+)
+
+// CircuitOpenError is returned without calling the upstream while its breaker is open.
+type CircuitOpenError struct {
+	Upstream string
+}
+
+func (e CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of upstream %s is open", e.Upstream)
+}
+
+func (e CircuitOpenError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e CircuitOpenError) Rejected() {}
+
+// upstreamFailure marks a response with a server error status as a failure for the breaker.
+type upstreamFailure struct {
+	statusCode int
+}
+
+func (e upstreamFailure) Error() string {
+	return fmt.Sprintf("upstream replied %d", e.statusCode)
+}
+
+// CircuitBreakerMiddleware fails fast while the breaker is open. Errors and responses
+// with server error statuses count as failures, requests cancelled by clients do not.
+func CircuitBreakerMiddleware(cb *gobreaker.CircuitBreaker) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			var callErr error
+
+			response, err := cb.Execute(func() (interface{}, error) {
+				response, err := next(ctx, request)
+				callErr = err
+
+				if err != nil && ctx.Err() == context.Canceled {
+					return response, nil
+				}
+
+				if proxyResponse, ok := response.(ProxyResponse); ok && proxyResponse.StatusCode >= http.StatusInternalServerError {
+					return response, upstreamFailure{proxyResponse.StatusCode}
+				}
+
+				return response, err
+			})
+
+			if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+				return nil, CircuitOpenError{cb.Name()}
+			}
+
+			return response, callErr
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import "context"
+
+// DegradedHeader marks replies served by a fallback instead of the route upstream,
+// its value is the kind of fallback.
+const DegradedHeader = "X-Degraded"
+
+type degradedKey struct{}
+
+// WithDegraded prepares the request context to tell whether its reply is degraded.
+func WithDegraded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, degradedKey{}, new(string))
+}
+
+// Degraded returns the kind of fallback that served the request, if any.
+func Degraded(ctx context.Context) string {
+	if degraded, ok := ctx.Value(degradedKey{}).(*string); ok {
+		return *degraded
+	}
+
+	return ""
+}
+
+func setDegraded(ctx context.Context, kind string) {
+	if degraded, ok := ctx.Value(degradedKey{}).(*string); ok {
+		*degraded = kind
+	}
+}
@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kinds of fallbacks served by FallbackMiddleware.
+const (
+	FallbackStale     = "stale"
+	FallbackAlternate = "alternate"
+	FallbackStatic    = "static"
+)
+
+// FallbackPolicy tells FallbackMiddleware what to serve when the primary call fails.
+// Successful responses of requests CacheKey finds a key for are kept for StaleAge and
+// served when later calls fail, proxied bodies larger than MaxBodyBytes are not kept.
+// Otherwise the request is sent to Alternate and at last Static is returned. Unset
+// fallbacks are skipped.
+type FallbackPolicy struct {
+	StaleAge     time.Duration
+	MaxBodyBytes int64
+	MaxEntries   int
+	CacheKey     func(ctx context.Context, request interface{}) (string, bool)
+	Alternate    endpoint.Endpoint
+	Static       *StaticResponse
+}
+
+// FallbackMiddleware serves a fallback when next fails with an error or a server error
+// status, including when its breaker is open. Replies served by a fallback are marked
+// with DegradedHeader. Requests cancelled by clients do not fall back.
+func FallbackMiddleware(policy FallbackPolicy, fallbacks metrics.Counter) endpoint.Middleware {
+	cache := newStaleCache(policy.StaleAge, policy.MaxEntries)
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key, cacheable := "", false
+
+			if cache != nil && policy.CacheKey != nil {
+				key, cacheable = policy.CacheKey(ctx, request)
+			}
+
+			replay, replayable := &replayableRequest{request: request}, true
+
+			if policy.Alternate != nil {
+				replay, replayable = newReplayableRequest(request, policy.MaxBodyBytes)
+			}
+
+			response, err := next(ctx, replay.next())
+
+			if !failed(response, err) || ctx.Err() == context.Canceled {
+				if cacheable && err == nil {
+					response = cache.store(key, response, policy.MaxBodyBytes)
+				}
+
+				return response, err
+			}
+
+			if cacheable {
+				if stale, ok := cache.load(key); ok {
+					discard(response)
+					return degrade(ctx, fallbacks, FallbackStale, stale)
+				}
+			}
+
+			if policy.Alternate != nil && replayable {
+				alternate, alternateErr := policy.Alternate(ctx, replay.next())
+
+				if !failed(alternate, alternateErr) {
+					discard(response)
+					return degrade(ctx, fallbacks, FallbackAlternate, alternate)
+				}
+
+				discard(alternate)
+			}
+
+			if policy.Static != nil {
+				discard(response)
+				return degrade(ctx, fallbacks, FallbackStatic, *policy.Static)
+			}
+
+			return response, err
+		}
+	}
+}
+
+// failed tells a failed call, server error statuses of proxied responses count as failures.
+func failed(response interface{}, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	proxyResponse, ok := response.(ProxyResponse)
+
+	return ok && proxyResponse.StatusCode >= http.StatusInternalServerError
+}
+
+func degrade(ctx context.Context, fallbacks metrics.Counter, kind string, response interface{}) (interface{}, error) {
+	fallbacks.With("kind", kind).Add(1)
+	setDegraded(ctx, kind)
+
+	return response, nil
+}
+
+type staleEntry struct {
+	response interface{}
+	body     []byte
+	stored   time.Time
+}
+
+// staleCache keeps the last successful responses for up to maxAge.
+type staleCache struct {
+	maxAge     time.Duration
+	maxEntries int
+
+	mtx     sync.Mutex
+	entries map[string]staleEntry
+}
+
+func newStaleCache(maxAge time.Duration, maxEntries int) *staleCache {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	return &staleCache{
+		maxAge:     maxAge,
+		maxEntries: maxEntries,
+		entries:    make(map[string]staleEntry),
+	}
+}
+
+// store keeps response if it succeeded, proxied responses are buffered and
+// returned with a body reading from the buffer.
+func (cache *staleCache) store(key string, response interface{}, maxBodyBytes int64) interface{} {
+	entry := staleEntry{response: response, stored: time.Now()}
+
+	if proxyResponse, ok := response.(ProxyResponse); ok {
+		if proxyResponse.StatusCode < http.StatusOK || proxyResponse.StatusCode >= http.StatusMultipleChoices {
+			return response
+		}
+
+		body, complete := bufferBody(proxyResponse.Body, maxBodyBytes)
+
+		if !complete {
+			proxyResponse.Body = body
+			return proxyResponse
+		}
+
+		entry.body, _ = ioutil.ReadAll(body)
+		proxyResponse.Body = ioutil.NopCloser(bytes.NewReader(entry.body))
+		proxyResponse.Header = proxyResponse.Header.Clone()
+		entry.response = proxyResponse
+	}
+
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	if _, ok := cache.entries[key]; !ok && cache.maxEntries > 0 && len(cache.entries) >= cache.maxEntries {
+		cache.evict()
+	}
+
+	cache.entries[key] = entry
+
+	return entry.response
+}
+
+// evict drops expired entries, or an arbitrary one if none has expired.
+func (cache *staleCache) evict() {
+	now := time.Now()
+	evicted := false
+
+	for key, entry := range cache.entries {
+		if now.Sub(entry.stored) > cache.maxAge {
+			delete(cache.entries, key)
+			evicted = true
+		}
+	}
+
+	if evicted {
+		return
+	}
+
+	for key := range cache.entries {
+		delete(cache.entries, key)
+		return
+	}
+}
+
+func (cache *staleCache) load(key string) (interface{}, bool) {
+	cache.mtx.Lock()
+	entry, ok := cache.entries[key]
+	cache.mtx.Unlock()
+
+	if !ok || time.Since(entry.stored) > cache.maxAge {
+		return nil, false
+	}
+
+	if proxyResponse, ok := entry.response.(ProxyResponse); ok {
+		proxyResponse.Header = proxyResponse.Header.Clone()
+		proxyResponse.Body = ioutil.NopCloser(bytes.NewReader(entry.body))
+
+		return proxyResponse, true
+	}
+
+	return entry.response, true
+}
+
+// bufferBody reads body up to maxBodyBytes and closes it. Larger bodies and the ones
+// failing to be read are returned unread past what was buffered and left open.
+func bufferBody(body io.ReadCloser, maxBodyBytes int64) (io.ReadCloser, bool) {
+	if body == nil {
+		return ioutil.NopCloser(bytes.NewReader(nil)), true
+	}
+
+	buffered, err := ioutil.ReadAll(io.LimitReader(body, maxBodyBytes+1))
+
+	if err != nil || int64(len(buffered)) > maxBodyBytes {
+		return readCloser{io.MultiReader(bytes.NewReader(buffered), body), body}, false
+	}
+
+	body.Close()
+
+	return ioutil.NopCloser(bytes.NewReader(buffered)), true
+}
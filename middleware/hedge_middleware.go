@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Results of hedged calls counted by HedgeMiddleware.
+const (
+	HedgeSent = "sent"
+	HedgeWon  = "won"
+)
+
+const (
+	hedgeLatencySamples = 512
+	hedgeWindow         = 1000
+)
+
+// HedgePolicy tells HedgeMiddleware when to send a second request. The hedge is sent
+// after Delay or, with Percentile set, after that percentile of the observed latency.
+// At most MaxPercent of the requests are hedged. Only requests Idempotent reports
+// as safe to repeat are hedged.
+type HedgePolicy struct {
+	Delay        time.Duration
+	Percentile   float64
+	MaxPercent   float64
+	MaxBodyBytes int64
+	Idempotent   func(request interface{}) bool
+}
+
+type hedger struct {
+	policy HedgePolicy
+
+	mtx       sync.Mutex
+	latencies []time.Duration
+	next      int
+	delay     time.Duration
+	requests  int
+	hedges    int
+}
+
+// HedgeMiddleware sends a second request when the first one is slow and returns
+// whichever succeeds first, the other one is cancelled. Attempts are remembered in
+// the context, so that clusters send the hedge to another instance while there is one.
+func HedgeMiddleware(policy HedgePolicy, hedges metrics.Counter) endpoint.Middleware {
+	h := &hedger{
+		policy:    policy,
+		latencies: make([]time.Duration, 0, hedgeLatencySamples),
+		delay:     policy.Delay,
+	}
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if policy.Idempotent != nil && !policy.Idempotent(request) {
+				return next(ctx, request)
+			}
+
+			replay, ok := newReplayableRequest(request, policy.MaxBodyBytes)
+
+			if !ok {
+				return next(ctx, replay.next())
+			}
+
+			return h.call(WithAttempts(ctx), next, replay, hedges)
+		}
+	}
+}
+
+type hedgeResult struct {
+	response interface{}
+	err      error
+	cancel   context.CancelFunc
+	attempt  int
+}
+
+func (h *hedger) call(ctx context.Context, next endpoint.Endpoint, replay *replayableRequest,
+	hedges metrics.Counter) (interface{}, error) {
+	begin := time.Now()
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+
+	start := func() {
+		callCtx, cancel := context.WithCancel(ctx)
+		request := replay.next()
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+
+		go func() {
+			response, err := next(callCtx, request)
+			results <- hedgeResult{response, err, cancel, attempt}
+		}()
+	}
+
+	// release cancels the calls still in flight and closes whatever they return
+	release := func(pending int, winner int) {
+		for attempt, cancel := range cancels {
+			if attempt != winner {
+				cancel()
+			}
+		}
+
+		go func() {
+			for ; pending > 0; pending-- {
+				discard((<-results).response)
+			}
+		}()
+	}
+
+	start()
+	pending := 1
+	var hedgeAfter <-chan time.Time
+
+	// Without a delay yet, percentile policies wait for enough latency samples
+	if delay := h.hedgeDelay(); delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		hedgeAfter = timer.C
+	}
+
+	var last hedgeResult
+
+	for {
+		select {
+		case <-hedgeAfter:
+			if pending == 1 && h.allowHedge() {
+				hedges.With("result", HedgeSent).Add(1)
+				start()
+				pending++
+			}
+		case result := <-results:
+			pending--
+
+			if result.err != nil && pending > 0 {
+				result.cancel()
+				last = result
+				continue
+			}
+
+			h.observe(time.Since(begin))
+
+			if result.err == nil && result.attempt > 0 {
+				hedges.With("result", HedgeWon).Add(1)
+			}
+
+			release(pending, result.attempt)
+
+			return h.finish(result)
+		case <-ctx.Done():
+			release(pending, -1)
+
+			if last.cancel != nil {
+				return nil, last.err
+			}
+
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// finish keeps the call context of a streamed response alive until its body is closed.
+func (h *hedger) finish(result hedgeResult) (interface{}, error) {
+	if proxyResponse, ok := result.response.(ProxyResponse); ok && result.err == nil {
+		proxyResponse.Body = newOnCloseBody(proxyResponse.Body, result.cancel)
+
+		return proxyResponse, nil
+	}
+
+	result.cancel()
+
+	return result.response, result.err
+}
+
+func (h *hedger) hedgeDelay() time.Duration {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return h.delay
+}
+
+// allowHedge keeps the share of hedged requests under MaxPercent within a window of requests.
+func (h *hedger) allowHedge() bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if float64(h.hedges+1)*100 > float64(h.requests)*h.policy.MaxPercent {
+		return false
+	}
+
+	h.hedges++
+
+	return true
+}
+
+// observe records the latency of a call and recomputes the hedge delay from the percentile.
+func (h *hedger) observe(latency time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.requests++
+
+	if h.requests >= hedgeWindow {
+		h.requests, h.hedges = 0, 0
+	}
+
+	if h.policy.Percentile <= 0 {
+		return
+	}
+
+	if len(h.latencies) < hedgeLatencySamples {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % hedgeLatencySamples
+	}
+
+	if len(h.latencies) < hedgeLatencySamples/8 || h.requests%16 != 0 {
+		return
+	}
+
+	sorted := append([]time.Duration(nil), h.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(float64(len(sorted)-1) * h.policy.Percentile / 100)
+
+	if index < 0 {
+		index = 0
+	} else if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+
+	h.delay = sorted[index]
+}
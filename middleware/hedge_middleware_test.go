@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHedgeDelayPercentileIsClamped(t *testing.T) {
+	for _, percentile := range []float64{100, 1000} {
+		h := &hedger{policy: HedgePolicy{Percentile: percentile}}
+
+		for i := 1; i <= hedgeLatencySamples; i++ {
+			h.observe(time.Duration(i) * time.Millisecond)
+		}
+
+		if want := hedgeLatencySamples * time.Millisecond; h.delay != want {
+			t.Errorf("percentile %v: delay %v, want %v", percentile, h.delay, want)
+		}
+	}
+}
@@ -7,15 +7,38 @@ import (
 	"time"
 )
 
+// Label names set by MetricsMiddleware, metrics passed to it must declare them.
+var MetricsLabels = []string{"endpoint", "variant", "version", "outcome"}
+
+// Outcomes of calls labelled by MetricsMiddleware.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+	OutcomeTimeout = "timeout"
+)
+
 func MetricsMiddleware(count metrics.Counter, latency metrics.Histogram, endpointName string) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
-		return func(ctx context.Context, request interface{}) (interface{}, error) {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 			defer func(begin time.Time) {
-				count.With(endpointName).Add(1)
-				latency.With(endpointName).Observe(time.Since(begin).Seconds())
+				labels := []string{"endpoint", endpointName, "variant", Variant(ctx), "version", Version(ctx),
+					"outcome", outcome(err)}
+				count.With(labels...).Add(1)
+				latency.With(labels...).Observe(time.Since(begin).Seconds())
 			}(time.Now())
 
 			return next(ctx, request)
 		}
 	}
 }
+
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case IsTimeout(err):
+		return OutcomeTimeout
+	default:
+		return OutcomeError
+	}
+}
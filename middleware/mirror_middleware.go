@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Results of comparing a mirrored response with the primary one.
+const (
+	MirrorMatch          = "match"
+	MirrorStatusMismatch = "status_mismatch"
+	MirrorBodyMismatch   = "body_mismatch"
+	MirrorError          = "error"
+	MirrorSkipped        = "skipped"
+)
+
+// MirrorOptions tunes MirrorMiddleware.
+type MirrorOptions struct {
+	Percent      float64
+	Timeout      time.Duration
+	MaxBodyBytes int64
+}
+
+// MirrorMiddleware asynchronously sends a sample of requests to shadow as well,
+// the caller gets only the primary response. Every mirrored request increments
+// results labelled with the outcome of the comparison.
+func MirrorMiddleware(shadow endpoint.Endpoint, options MirrorOptions, results metrics.Counter,
+	logger log.Logger) endpoint.Middleware {
+	var (
+		mtx    sync.Mutex
+		random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	)
+
+	sample := func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		return random.Float64()*100 < options.Percent
+	}
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if !sample() {
+				return next(ctx, request)
+			}
+
+			primaryRequest, shadowRequest, ok := duplicateRequest(request, options.MaxBodyBytes)
+
+			if !ok {
+				results.With("result", MirrorSkipped).Add(1)
+				return next(ctx, primaryRequest)
+			}
+
+			shadowCtx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+			shadowCtx = WithVariant(shadowCtx, Variant(ctx))
+			primary := make(chan mirrorResult, 1)
+
+			go func() {
+				defer cancel()
+				shadowResult := callShadow(shadowCtx, shadow, shadowRequest)
+
+				select {
+				case primaryResult := <-primary:
+					result := compareMirror(primaryResult, shadowResult)
+					results.With("result", result).Add(1)
+
+					if result != MirrorMatch {
+						logger.Log("msg", "mirrored response differs", "result", result)
+					}
+				case <-time.After(options.Timeout):
+					// The primary response is still being streamed, there is nothing to compare with
+					results.With("result", MirrorSkipped).Add(1)
+				}
+			}()
+
+			response, err := next(ctx, primaryRequest)
+
+			if proxyResponse, ok := response.(ProxyResponse); ok && err == nil {
+				// Digest of the primary body is known only once it is streamed to the client
+				proxyResponse.Body = newDigestBody(proxyResponse.Body, func(digest []byte) {
+					primary <- mirrorResult{status: proxyResponse.StatusCode, digest: digest}
+				})
+
+				return proxyResponse, nil
+			}
+
+			primary <- mirrorResult{response: response, err: err}
+
+			return response, err
+		}
+	}
+}
+
+type mirrorResult struct {
+	response interface{}
+	err      error
+	status   int
+	digest   []byte
+}
+
+func callShadow(ctx context.Context, shadow endpoint.Endpoint, request interface{}) mirrorResult {
+	response, err := shadow(ctx, request)
+
+	if err != nil {
+		return mirrorResult{err: err}
+	}
+
+	proxyResponse, ok := response.(ProxyResponse)
+
+	if !ok {
+		return mirrorResult{response: response}
+	}
+
+	defer proxyResponse.Body.Close()
+	digest := sha256.New()
+
+	if _, err := io.Copy(digest, proxyResponse.Body); err != nil {
+		return mirrorResult{err: err}
+	}
+
+	return mirrorResult{status: proxyResponse.StatusCode, digest: digest.Sum(nil)}
+}
+
+func compareMirror(primary, shadow mirrorResult) string {
+	if shadow.err != nil && primary.err == nil {
+		return MirrorError
+	}
+
+	if (primary.err == nil) != (shadow.err == nil) || primary.status != shadow.status {
+		return MirrorStatusMismatch
+	}
+
+	if primary.digest != nil || shadow.digest != nil {
+		if !bytes.Equal(primary.digest, shadow.digest) {
+			return MirrorBodyMismatch
+		}
+
+		return MirrorMatch
+	}
+
+	if primary.err != nil && primary.err.Error() != shadow.err.Error() {
+		return MirrorBodyMismatch
+	}
+
+	if !reflect.DeepEqual(primary.response, shadow.response) {
+		return MirrorBodyMismatch
+	}
+
+	return MirrorMatch
+}
+
+// duplicateRequest makes a copy of the request for the shadow. Streamed bodies are
+// buffered up to maxBodyBytes, when they are larger the request is not mirrored.
+func duplicateRequest(request interface{}, maxBodyBytes int64) (interface{}, interface{}, bool) {
+	proxyRequest, ok := request.(ProxyRequest)
+
+	if !ok {
+		return request, request, true
+	}
+
+	if proxyRequest.ContentLength > maxBodyBytes {
+		return request, nil, false
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(proxyRequest.Body, maxBodyBytes+1))
+
+	if err != nil || int64(len(body)) > maxBodyBytes {
+		proxyRequest.Body = readCloser{io.MultiReader(bytes.NewReader(body), proxyRequest.Body), proxyRequest.Body}
+
+		return proxyRequest, nil, false
+	}
+
+	shadowRequest := proxyRequest
+	shadowRequest.Header = make(map[string][]string, len(proxyRequest.Header))
+
+	for name, values := range proxyRequest.Header {
+		shadowRequest.Header[name] = append([]string(nil), values...)
+	}
+
+	proxyRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+	shadowRequest.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return proxyRequest, shadowRequest, true
+}
+
+// digestBody hashes a body while it is read and reports the digest once it is closed.
+type digestBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	once sync.Once
+	done func([]byte)
+}
+
+func newDigestBody(body io.ReadCloser, done func([]byte)) *digestBody {
+	return &digestBody{ReadCloser: body, hash: sha256.New(), done: done}
+}
+
+func (body *digestBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.hash.Write(p[:n])
+
+	return n, err
+}
+
+func (body *digestBody) Close() error {
+	body.once.Do(func() {
+		body.done(body.hash.Sum(nil))
+	})
+
+	return body.ReadCloser.Close()
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+	. "api-gateway/transports"
+
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Classes of errors a retry policy can retry on.
+const (
+	RetryOnConnect = "connect"
+	RetryOnTimeout = "timeout"
+	RetryOnReset   = "reset"
+)
+
+// RetryPolicy tells RetryMiddleware when and how often to repeat a request.
+// Idempotent reports whether the request can be repeated, requests that are not
+// idempotent are retried only with NonIdempotent set or with an idempotency key.
+type RetryPolicy struct {
+	MaxAttempts          int
+	PerTryTimeout        time.Duration
+	BaseBackoff          time.Duration
+	MaxBackoff           time.Duration
+	RetryableStatusCodes []int
+	RetryOn              []string
+	NonIdempotent        bool
+	MaxBodyBytes         int64
+	Idempotent           func(request interface{}) bool
+}
+
+// RetryMiddleware repeats failed requests with exponential backoff and full jitter.
+// Attempts are remembered in the context, so that clusters send retries to instances
+// not tried yet while there are any. Retries are counted by retries.
+func RetryMiddleware(policy RetryPolicy, retries metrics.Counter, logger log.Logger) endpoint.Middleware {
+	var (
+		mtx    sync.Mutex
+		random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	)
+
+	backoff := func(attempt int) time.Duration {
+		d := policy.BaseBackoff << uint(attempt-1)
+
+		if d > policy.MaxBackoff || d <= 0 {
+			d = policy.MaxBackoff
+		}
+
+		if d <= 0 {
+			return 0
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		return time.Duration(random.Int63n(int64(d) + 1))
+	}
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if policy.MaxAttempts <= 1 || !policy.allowed(ctx, request) {
+				return next(ctx, request)
+			}
+
+			replay, ok := newReplayableRequest(request, policy.MaxBodyBytes)
+
+			if !ok {
+				return next(ctx, replay.next())
+			}
+
+			ctx = WithAttempts(ctx)
+
+			for attempt := 1; ; attempt++ {
+				response, err := policy.try(ctx, next, replay.next())
+
+				if attempt >= policy.MaxAttempts || !policy.retryable(ctx, response, err) {
+					return response, err
+				}
+
+				discard(response)
+				retries.Add(1)
+				logger.Log("msg", "retrying request", "attempt", attempt+1, "err", err)
+
+				select {
+				case <-time.After(backoff(attempt)):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+func (policy RetryPolicy) allowed(ctx context.Context, request interface{}) bool {
+	return policy.NonIdempotent || len(IdempotencyKey(ctx)) > 0 ||
+		policy.Idempotent == nil || policy.Idempotent(request)
+}
+
+// try makes a single attempt limited by the per try timeout. Streamed responses
+// keep the attempt context alive until their body is closed.
+func (policy RetryPolicy) try(ctx context.Context, next endpoint.Endpoint, request interface{}) (interface{}, error) {
+	if policy.PerTryTimeout <= 0 {
+		return next(ctx, request)
+	}
+
+	tryCtx, cancel := context.WithTimeout(ctx, policy.PerTryTimeout)
+	response, err := next(tryCtx, request)
+
+	if proxyResponse, ok := response.(ProxyResponse); ok && err == nil {
+		proxyResponse.Body = newOnCloseBody(proxyResponse.Body, cancel)
+
+		return proxyResponse, nil
+	}
+
+	cancel()
+
+	return response, err
+}
+
+func (policy RetryPolicy) retryable(ctx context.Context, response interface{}, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err == nil {
+		if proxyResponse, ok := response.(ProxyResponse); ok {
+			return policy.retryableStatus(proxyResponse.StatusCode)
+		}
+
+		return false
+	}
+
+	var statusErr StatusError
+
+	if errors.As(err, &statusErr) && policy.retryableStatus(statusErr.Code) {
+		return true
+	}
+
+	for _, class := range policy.RetryOn {
+		if errorClass(err) == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (policy RetryPolicy) retryableStatus(statusCode int) bool {
+	for _, code := range policy.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errorClass sorts transport errors into the classes a policy can retry on.
+func errorClass(err error) string {
+	var opErr *net.OpError
+
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return RetryOnConnect
+	case IsTimeout(err):
+		return RetryOnTimeout
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return RetryOnReset
+	}
+
+	return ""
+}
+
+// replayableRequest hands out a fresh copy of the request for every attempt.
+type replayableRequest struct {
+	request interface{}
+	body    []byte
+}
+
+// newReplayableRequest buffers a streamed body up to maxBodyBytes,
+// larger bodies can be sent only once.
+func newReplayableRequest(request interface{}, maxBodyBytes int64) (*replayableRequest, bool) {
+	proxyRequest, ok := request.(ProxyRequest)
+
+	if !ok || proxyRequest.Body == nil || proxyRequest.Body == http.NoBody || proxyRequest.ContentLength == 0 {
+		return &replayableRequest{request: request}, true
+	}
+
+	if proxyRequest.ContentLength > maxBodyBytes {
+		return &replayableRequest{request: request}, false
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(proxyRequest.Body, maxBodyBytes+1))
+
+	if err != nil || int64(len(body)) > maxBodyBytes {
+		proxyRequest.Body = readCloser{io.MultiReader(bytes.NewReader(body), proxyRequest.Body), proxyRequest.Body}
+
+		return &replayableRequest{request: proxyRequest}, false
+	}
+
+	return &replayableRequest{request: proxyRequest, body: body}, true
+}
+
+func (replay *replayableRequest) next() interface{} {
+	if replay.body == nil {
+		return replay.request
+	}
+
+	proxyRequest := replay.request.(ProxyRequest)
+	proxyRequest.Body = ioutil.NopCloser(bytes.NewReader(replay.body))
+
+	return proxyRequest
+}
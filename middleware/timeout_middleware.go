@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// GatewayTimeoutError is returned when the gateway gives up waiting for an upstream.
+type GatewayTimeoutError struct {
+	Err error
+}
+
+func (e GatewayTimeoutError) Error() string {
+	return "gateway timeout: " + e.Err.Error()
+}
+
+func (e GatewayTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (e GatewayTimeoutError) StatusCode() int {
+	return http.StatusGatewayTimeout
+}
+
+// TimeoutMiddleware limits calls to next by timeout, zero means no limit of its own.
+// Either way upstream timeouts are reported as GatewayTimeoutError. Streamed
+// responses keep their context alive until their body is closed.
+func TimeoutMiddleware(timeout time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			cancel := context.CancelFunc(func() {})
+
+			if timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+			}
+
+			response, err := next(ctx, request)
+
+			if proxyResponse, ok := response.(ProxyResponse); ok && err == nil {
+				proxyResponse.Body = newOnCloseBody(proxyResponse.Body, cancel)
+
+				return proxyResponse, nil
+			}
+
+			cancel()
+
+			var timeoutErr GatewayTimeoutError
+
+			if err != nil && !errors.As(err, &timeoutErr) && IsTimeout(err) {
+				return response, GatewayTimeoutError{err}
+			}
+
+			return response, err
+		}
+	}
+}
+
+// IsTimeout reports whether err means that a deadline of the request was exceeded.
+func IsTimeout(err error) bool {
+	var (
+		timeoutErr GatewayTimeoutError
+		netErr     net.Error
+	)
+
+	return errors.As(err, &timeoutErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -3,6 +3,8 @@ package middleware
 import (
 	"api-gateway"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/go-kit/kit/log"
 )
 
@@ -26,18 +28,18 @@ func (mw LoggingMiddleWare) IssueToken(ctx context.Context, login, password stri
 	return token, err
 }
 
-func (mw LoggingMiddleWare) VerifyToken(ctx context.Context, token string) error {
-	mw.Logger.Log("method", "VerifyToken", "token", token)
-	err := mw.next.VerifyToken(ctx, token)
-	mw.Logger.Log("method", "VerifyToken", "error", err.Error())
+func (mw LoggingMiddleWare) VerifyToken(ctx context.Context, token string) (api_gateway.Identity, error) {
+	mw.Logger.Log("method", "VerifyToken", "token", fingerprint(token))
+	identity, err := mw.next.VerifyToken(ctx, token)
+	mw.Logger.Log("method", "VerifyToken", "subject", identity.Subject, "error", err)
 
-	return err
+	return identity, err
 }
 
 func (mw LoggingMiddleWare) RevokeToken(ctx context.Context, token string) error {
-	mw.Logger.Log("method", "RevokeToken", "token", token)
+	mw.Logger.Log("method", "RevokeToken", "token", fingerprint(token))
 	err := mw.next.RevokeToken(ctx, token)
-	mw.Logger.Log("method", "RevokeToken", "error", err.Error())
+	mw.Logger.Log("method", "RevokeToken", "error", err)
 
 	return err
 }
@@ -49,3 +51,10 @@ func (mw LoggingMiddleWare) HealthCheck() bool {
 
 	return status
 }
+
+// fingerprint tells tokens apart in the logs without revealing them.
+func fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:4])
+}
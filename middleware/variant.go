@@ -0,0 +1,17 @@
+package middleware
+
+import "context"
+
+type variantKey struct{}
+
+// WithVariant stores the name of the route variant chosen for the request.
+func WithVariant(ctx context.Context, variant string) context.Context {
+	return context.WithValue(ctx, variantKey{}, variant)
+}
+
+// Variant returns the route variant chosen for the request, if any.
+func Variant(ctx context.Context) string {
+	variant, _ := ctx.Value(variantKey{}).(string)
+
+	return variant
+}
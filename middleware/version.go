@@ -0,0 +1,17 @@
+package middleware
+
+import "context"
+
+type versionKey struct{}
+
+// WithVersion stores the API version chosen for the request.
+func WithVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// Version returns the API version chosen for the request, if any.
+func Version(ctx context.Context) string {
+	version, _ := ctx.Value(versionKey{}).(string)
+
+	return version
+}
@@ -0,0 +1,24 @@
+package registration
+
+import (
+	. "api-gateway"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd/consul"
+	"github.com/hashicorp/consul/api"
+)
+
+// NewConsulClient connects to the consul agent from the service discovery config.
+func NewConsulClient(config *TomlConfig, logger log.Logger) consul.Client {
+	consulConfig := api.DefaultConfig()
+	consulConfig.Address = fmt.Sprintf("%s:%d",
+		config.ServiceDiscovery.ConsulAddress,
+		config.ServiceDiscovery.ConsulPort)
+	consulClient, err := api.NewClient(consulConfig)
+
+	if err != nil {
+		logger.Log("message", "Can not find consul to do service discovery", "err", err)
+	}
+
+	return consul.NewClient(consulClient)
+}
@@ -16,20 +16,7 @@ import (
 func NewRegistrar(config *TomlConfig, logger log.Logger) (registar sd.Registrar) {
 	rand.Seed(time.Now().UnixNano())
 
-	var client consul.Client
-	{
-		consulConfig := api.DefaultConfig()
-		consulConfig.Address = fmt.Sprintf("%s:%d",
-			config.ServiceDiscovery.ConsulAddress,
-			config.ServiceDiscovery.ConsulPort)
-		consulClient, err := api.NewClient(consulConfig)
-
-		if err != nil {
-			logger.Log("message", "Can not find consul to do service discovery", "err", err)
-		}
-
-		client = consul.NewClient(consulClient)
-	}
+	client := NewConsulClient(config, logger)
 
 	check := api.AgentServiceCheck{
 		HTTP: "http://" +
@@ -0,0 +1,222 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Identity headers pass the verified token owner to upstreams.
+const (
+	SubjectHeader = "X-Auth-Subject"
+	ScopesHeader  = "X-Auth-Scopes"
+)
+
+const defaultAuthRealm = "api-gateway"
+
+// Authenticator lets requests of protected routes through only with a token
+// verified by the token service or with an API key.
+type Authenticator struct {
+	tokenService TokenService
+	apiKeys      APIKeyService
+	config       AuthConfig
+}
+
+func NewAuthenticator(tokenService TokenService, apiKeys APIKeyService, config AuthConfig) (*Authenticator, error) {
+	if tokenService == nil {
+		return nil, errors.New("authentication needs the token service")
+	}
+
+	if apiKeys == nil && (len(config.APIKeyHeader) > 0 || len(config.APIKeyQuery) > 0) {
+		return nil, errors.New("api keys are not enabled")
+	}
+
+	if len(config.Realm) == 0 {
+		config.Realm = defaultAuthRealm
+	}
+
+	return &Authenticator{
+		tokenService: tokenService,
+		apiKeys:      apiKeys,
+		config:       config,
+	}, nil
+}
+
+// Handler verifies the token of the request and passes the identity to next
+// in the identity headers, the ones sent by the client are dropped.
+func (authenticator *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stripIdentity(r)
+
+		var (
+			identity Identity
+			err      error
+		)
+
+		if key, ok := authenticator.apiKey(r); ok {
+			identity, err = authenticator.apiKeys.VerifyKey(r.Context(), key)
+		} else if token, ok := authenticator.token(r); ok {
+			identity, err = authenticator.tokenService.VerifyToken(r.Context(), token)
+		} else {
+			authenticator.challenge(w, http.StatusUnauthorized, "", "")
+			return
+		}
+
+		if err != nil {
+			var invalid InvalidTokenError
+
+			if !errors.As(err, &invalid) {
+				http.Error(w, "token can not be verified", http.StatusServiceUnavailable)
+				return
+			}
+
+			authenticator.challenge(w, http.StatusUnauthorized, "invalid_token", invalid.Reason)
+			return
+		}
+
+		if missing := missingScopes(identity.Scopes, authenticator.config.Scopes); len(missing) > 0 {
+			authenticator.challenge(w, http.StatusForbidden, "insufficient_scope",
+				"token lacks scopes "+strings.Join(missing, " "))
+			return
+		}
+
+		r.Header.Set(SubjectHeader, identity.Subject)
+
+		if len(identity.Scopes) > 0 {
+			r.Header.Set(ScopesHeader, strings.Join(identity.Scopes, " "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiKey takes the API key from the configured header or else from the configured query parameter,
+// both are removed so that the key is not passed to the upstream.
+func (authenticator *Authenticator) apiKey(r *http.Request) (string, bool) {
+	var key string
+
+	if header := authenticator.config.APIKeyHeader; len(header) > 0 {
+		key = r.Header.Get(header)
+		r.Header.Del(header)
+	}
+
+	if param := authenticator.config.APIKeyQuery; len(param) > 0 {
+		rawQuery, value, found := removeQueryParam(r.URL.RawQuery, param)
+
+		if found {
+			if len(key) == 0 {
+				key = value
+			}
+
+			r.URL.RawQuery = rawQuery
+		}
+	}
+
+	return key, len(key) > 0
+}
+
+// removeQueryParam drops the pairs of param from rawQuery and returns the first value,
+// other pairs are kept as they are so that their order and encoding do not change.
+func removeQueryParam(rawQuery, param string) (string, string, bool) {
+	var (
+		kept  []string
+		value string
+		found bool
+	)
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		name, pairValue := pair, ""
+
+		if i := strings.Index(pair, "="); i >= 0 {
+			name, pairValue = pair[:i], pair[i+1:]
+		}
+
+		if unescaped, err := url.QueryUnescape(name); err != nil || unescaped != param {
+			kept = append(kept, pair)
+			continue
+		}
+
+		if !found {
+			value, _ = url.QueryUnescape(pairValue)
+			found = true
+		}
+	}
+
+	return strings.Join(kept, "&"), value, found
+}
+
+// token takes a bearer token from the Authorization header or else from the configured cookie.
+func (authenticator *Authenticator) token(r *http.Request) (string, bool) {
+	if authorization := r.Header.Get("Authorization"); len(authorization) > 0 {
+		parts := strings.SplitN(authorization, " ", 2)
+
+		if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") && len(strings.TrimSpace(parts[1])) > 0 {
+			return strings.TrimSpace(parts[1]), true
+		}
+
+		return "", false
+	}
+
+	if len(authenticator.config.Cookie) > 0 {
+		if cookie, err := r.Cookie(authenticator.config.Cookie); err == nil && len(cookie.Value) > 0 {
+			return cookie.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// challenge rejects the request with a bearer challenge as described by RFC 6750.
+func (authenticator *Authenticator) challenge(w http.ResponseWriter, status int, code, description string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authenticator.config.Realm)
+
+	if len(code) > 0 {
+		challenge += fmt.Sprintf(", error=%q", code)
+	}
+
+	if len(description) > 0 {
+		challenge += fmt.Sprintf(", error_description=%q", strings.Replace(description, `"`, "'", -1))
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func missingScopes(granted, required []string) []string {
+	var missing []string
+
+	for _, scope := range required {
+		found := false
+
+		for _, grantedScope := range granted {
+			if grantedScope == scope {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// stripIdentity drops identity headers sent by clients, only the gateway sets them.
+func stripIdentity(r *http.Request) {
+	r.Header.Del(SubjectHeader)
+	r.Header.Del(ScopesHeader)
+}
+
+// stripIdentityHandler drops identity headers sent by clients of routes that are not protected.
+func stripIdentityHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stripIdentity(r)
+		next.ServeHTTP(w, r)
+	})
+}
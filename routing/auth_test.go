@@ -0,0 +1,242 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubTokenService knows the tokens "reader" and "admin", fails on "outage" and rejects others.
+type stubTokenService struct{}
+
+func (stubTokenService) IssueToken(context.Context, string, string) (string, error) {
+	return "", nil
+}
+
+func (stubTokenService) VerifyToken(_ context.Context, token string) (Identity, error) {
+	switch token {
+	case "reader":
+		return Identity{Subject: "alice", Scopes: []string{"orders:read"}}, nil
+	case "admin":
+		return Identity{Subject: "root", Scopes: []string{"orders:read", "admin"}}, nil
+	case "outage":
+		return Identity{}, errors.New("token service unavailable")
+	default:
+		return Identity{}, InvalidTokenError{Reason: "unknown token"}
+	}
+}
+
+func (stubTokenService) RevokeToken(context.Context, string) error {
+	return nil
+}
+
+func (stubTokenService) HealthCheck() bool {
+	return true
+}
+
+// stubAPIKeys knows the key "key.secret" of the batch client.
+type stubAPIKeys struct {
+	APIKeyService
+}
+
+func (stubAPIKeys) VerifyKey(_ context.Context, key string) (Identity, error) {
+	if key == "key.secret" {
+		return Identity{Subject: "batch", Scopes: []string{"orders:read"}}, nil
+	}
+
+	return Identity{}, InvalidTokenError{Reason: "unknown key"}
+}
+
+// authenticate sends r through an authenticator and returns the response and the request
+// the upstream got, which is nil when the request was rejected.
+func authenticate(t *testing.T, config AuthConfig, r *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+	t.Helper()
+	authenticator, err := NewAuthenticator(stubTokenService{}, stubAPIKeys{}, config)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var forwarded *http.Request
+	w := httptest.NewRecorder()
+	authenticator.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})).ServeHTTP(w, r)
+
+	return w, forwarded
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
+
+func TestAuthenticatorPassesVerifiedIdentity(t *testing.T) {
+	r := bearer("reader")
+	r.Header.Set(SubjectHeader, "root")
+	r.Header.Set(ScopesHeader, "admin")
+
+	w, forwarded := authenticate(t, AuthConfig{Required: true, Scopes: []string{"orders:read"}}, r)
+
+	if forwarded == nil {
+		t.Fatalf("request rejected with %d", w.Code)
+	}
+
+	if subject := forwarded.Header.Get(SubjectHeader); subject != "alice" {
+		t.Errorf("subject %q, want the verified one", subject)
+	}
+
+	if scopes := forwarded.Header.Get(ScopesHeader); scopes != "orders:read" {
+		t.Errorf("scopes %q, want the verified ones", scopes)
+	}
+}
+
+func TestAuthenticatorStripsClientIdentityWithoutScopes(t *testing.T) {
+	r := bearer("admin")
+	r.Header.Set(SubjectHeader, "mallory")
+	w, forwarded := authenticate(t, AuthConfig{Required: true}, r)
+
+	if forwarded == nil {
+		t.Fatalf("request rejected with %d", w.Code)
+	}
+
+	if got := forwarded.Header[SubjectHeader]; len(got) != 1 || got[0] != "root" {
+		t.Errorf("subject headers %q, want only the verified one", got)
+	}
+}
+
+func TestStripIdentityHandlerDropsClientIdentity(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/public", nil)
+	r.Header.Set(SubjectHeader, "root")
+	r.Header.Set(ScopesHeader, "admin")
+
+	var forwarded *http.Request
+	stripIdentityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+	})).ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(forwarded.Header.Get(SubjectHeader)) > 0 || len(forwarded.Header.Get(ScopesHeader)) > 0 {
+		t.Errorf("identity headers %v reach the upstream", forwarded.Header)
+	}
+}
+
+func TestAuthenticatorRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		request   *http.Request
+		status    int
+		challenge string
+	}{
+		{"missing token", httptest.NewRequest(http.MethodGet, "/orders", nil), http.StatusUnauthorized,
+			`Bearer realm="orders"`},
+		{"not a bearer token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.Header.Set("Authorization", "Basic YWxpY2U6c2VjcmV0")
+			return r
+		}(), http.StatusUnauthorized, `Bearer realm="orders"`},
+		{"invalid token", bearer("forged"), http.StatusUnauthorized,
+			`Bearer realm="orders", error="invalid_token", error_description="unknown token"`},
+		{"invalid api key", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.Header.Set("X-API-Key", "key.wrong")
+			return r
+		}(), http.StatusUnauthorized, `Bearer realm="orders", error="invalid_token", error_description="unknown key"`},
+		{"missing scopes", bearer("reader"), http.StatusForbidden,
+			`Bearer realm="orders", error="insufficient_scope", error_description="token lacks scopes orders:write"`},
+		{"token service error", bearer("outage"), http.StatusServiceUnavailable, ""},
+	}
+
+	config := AuthConfig{
+		Required:     true,
+		Realm:        "orders",
+		Scopes:       []string{"orders:read", "orders:write"},
+		APIKeyHeader: "X-API-Key",
+	}
+
+	for _, test := range tests {
+		test.request.Header.Set(SubjectHeader, "root")
+		w, forwarded := authenticate(t, config, test.request)
+
+		if forwarded != nil {
+			t.Errorf("%s: request reached the upstream", test.name)
+		}
+
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+		}
+
+		if challenge := w.Header().Get("WWW-Authenticate"); challenge != test.challenge {
+			t.Errorf("%s: challenge %q, want %q", test.name, challenge, test.challenge)
+		}
+	}
+}
+
+func TestAuthenticatorTakesTokensFromCookies(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "reader"})
+
+	if _, forwarded := authenticate(t, AuthConfig{Required: true, Cookie: "session"}, r); forwarded == nil {
+		t.Error("token of the cookie is not accepted")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.AddCookie(&http.Cookie{Name: "other", Value: "reader"})
+
+	if w, _ := authenticate(t, AuthConfig{Required: true, Cookie: "session"}, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("other cookie: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthenticatorRemovesAPIKeys(t *testing.T) {
+	config := AuthConfig{Required: true, APIKeyHeader: "X-API-Key", APIKeyQuery: "api_key"}
+
+	r := httptest.NewRequest(http.MethodGet, "/orders?id=1", nil)
+	r.Header.Set("X-API-Key", "key.secret")
+	_, forwarded := authenticate(t, config, r)
+
+	if forwarded == nil || len(forwarded.Header.Get("X-API-Key")) > 0 || forwarded.Header.Get(SubjectHeader) != "batch" {
+		t.Fatalf("key of the header: forwarded %v", forwarded)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/orders?id=1&api_key=key.secret", nil)
+	_, forwarded = authenticate(t, config, r)
+
+	if forwarded == nil || strings.Contains(forwarded.URL.RawQuery, "api_key") || forwarded.URL.Query().Get("id") != "1" {
+		t.Fatalf("key of the query: forwarded %v", forwarded)
+	}
+}
+
+func TestRemoveQueryParamKeepsOtherPairs(t *testing.T) {
+	tests := []struct {
+		rawQuery, rest, value string
+		found                 bool
+	}{
+		{"b=2&api_key=k1&a=1", "b=2&a=1", "k1", true},
+		{"q=a+b%2Fc&api_key=k%2E1&flag&x=%zz", "q=a+b%2Fc&flag&x=%zz", "k.1", true},
+		{"api%5Fkey=k1&api_key=k2&list=1&list=2", "list=1&list=2", "k1", true},
+		{"api_key", "", "", true},
+		{"api_keys=k1&x_api_key=k2", "api_keys=k1&x_api_key=k2", "", false},
+		{"", "", "", false},
+	}
+
+	for _, test := range tests {
+		rest, value, found := removeQueryParam(test.rawQuery, "api_key")
+
+		if rest != test.rest || value != test.value || found != test.found {
+			t.Errorf("%q: got %q, %q, %v", test.rawQuery, rest, value, found)
+		}
+	}
+}
+
+func TestAuthenticatorNeedsAPIKeysForKeyConfig(t *testing.T) {
+	if _, err := NewAuthenticator(stubTokenService{}, nil, AuthConfig{Required: true, APIKeyHeader: "X-API-Key"}); err == nil {
+		t.Error("api key header is accepted without api keys")
+	}
+}
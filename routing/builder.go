@@ -0,0 +1,329 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+	"api-gateway/upstreams"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+)
+
+// Handler knows how to build the endpoint behind a route and how to
+// decode requests into it and encode its responses. Idempotent reports
+// whether a decoded request can be safely repeated, CacheKey keys the responses
+// that may be served again and is nil for handlers whose responses may not.
+type Handler struct {
+	MakeEndpoint func(route RouteConfig) (endpoint.Endpoint, error)
+	Decode       httptransport.DecodeRequestFunc
+	Encode       httptransport.EncodeResponseFunc
+	Idempotent   func(request interface{}) bool
+	CacheKey     func(ctx context.Context, request interface{}) (string, bool)
+}
+
+var routeNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// MiddlewareFactory makes the named endpoint middleware for a route.
+type MiddlewareFactory func(route RouteConfig) (endpoint.Middleware, error)
+
+// Builder turns the route table from the config into a Router.
+type Builder struct {
+	config       *TomlConfig
+	logger       log.Logger
+	tokenService TokenService
+	upstreams    *upstreams.Registry
+	handlers     map[string]Handler
+	middlewares  map[string]MiddlewareFactory
+	counters     map[string]*kitprometheus.Counter
+	gauges       map[string]*kitprometheus.Gauge
+	shedder      *LoadShedder
+	revoked      []func(token string)
+	apiKeys      APIKeyService
+}
+
+func NewBuilder(config *TomlConfig, logger log.Logger, tokenService TokenService,
+	upstreams *upstreams.Registry) *Builder {
+	builder := &Builder{
+		config:       config,
+		logger:       logger,
+		tokenService: tokenService,
+		upstreams:    upstreams,
+		handlers:     make(map[string]Handler),
+		middlewares:  make(map[string]MiddlewareFactory),
+		counters:     make(map[string]*kitprometheus.Counter),
+		gauges:       make(map[string]*kitprometheus.Gauge),
+	}
+
+	builder.registerHandlers()
+	builder.registerMiddlewares()
+
+	return builder
+}
+
+// Handle registers a handler type that routes can refer to.
+func (builder *Builder) Handle(name string, handler Handler) {
+	builder.handlers[name] = handler
+}
+
+// Use registers a middleware that routes can refer to.
+func (builder *Builder) Use(name string, factory MiddlewareFactory) {
+	builder.middlewares[name] = factory
+}
+
+// OnTokenRevoked registers a function told about tokens revoked through revokeToken routes.
+func (builder *Builder) OnTokenRevoked(listener func(token string)) {
+	builder.revoked = append(builder.revoked, listener)
+}
+
+// UseAPIKeys enables API key authentication and the handlers managing keys with service.
+func (builder *Builder) UseAPIKeys(service APIKeyService) {
+	builder.apiKeys = service
+}
+
+func (builder *Builder) Build() (*Router, error) {
+	router := NewRouter()
+
+	if err := builder.buildLoadShedder(); err != nil {
+		return nil, errors.Wrap(err, "load shedding")
+	}
+
+	names := make(map[string]bool, len(builder.config.Routes))
+
+	for _, routeConfig := range builder.config.Routes {
+		// Route names are part of metric names
+		if !routeNamePattern.MatchString(routeConfig.Name) {
+			return nil, errors.New(fmt.Sprintf("route name %q must match %s", routeConfig.Name, routeNamePattern))
+		}
+
+		if names[routeConfig.Name] {
+			return nil, errors.New(fmt.Sprintf("route name %q is used more than once", routeConfig.Name))
+		}
+
+		names[routeConfig.Name] = true
+		route, err := builder.buildRoute(routeConfig)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "route %s", routeConfig.Name)
+		}
+
+		router.Add(route)
+	}
+
+	return router, nil
+}
+
+func (builder *Builder) buildRoute(routeConfig RouteConfig) (*Route, error) {
+	if len(routeConfig.Path) == 0 && len(routeConfig.Prefix) == 0 {
+		return nil, errors.New("either path or prefix must be set")
+	}
+
+	handler, ok := builder.handlers[routeConfig.Handler]
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown handler %q", routeConfig.Handler))
+	}
+
+	if len(routeConfig.Priority) == 0 {
+		routeConfig.Priority = PriorityNormal
+	}
+
+	if err := validPriority(routeConfig.Priority); err != nil {
+		return nil, err
+	}
+
+	var (
+		e         endpoint.Endpoint
+		options   []httptransport.ServerOption
+		versioner *Versioner
+		err       error
+	)
+
+	if len(routeConfig.Versions) > 0 {
+		if len(routeConfig.Split) > 0 {
+			return nil, errors.New("route can not be both versioned and split")
+		}
+
+		versioner, err = NewVersioner(routeConfig.Versions, func(upstream string) (endpoint.Endpoint, error) {
+			versionConfig := routeConfig
+
+			if len(upstream) > 0 {
+				versionConfig.Upstream = upstream
+			}
+
+			e, _, err := builder.makeEndpoint(handler, versionConfig)
+
+			return e, err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		e = versioner.Endpoint()
+	} else if e, options, err = builder.makeEndpoint(handler, routeConfig); err != nil {
+		return nil, err
+	}
+
+	if routeConfig.Bulkhead.MaxInFlight > 0 {
+		e = BulkheadMiddleware(NewBulkhead(routeConfig.Name,
+			routeConfig.Bulkhead.MaxInFlight,
+			routeConfig.Bulkhead.MaxQueued,
+			routeConfig.Bulkhead.QueueTimeout.Duration,
+			routeConfig.Bulkhead.RetryAfter.Duration,
+			builder.routeGauge(routeConfig.Name, "in_flight", "Calls in flight"),
+			builder.routeGauge(routeConfig.Name, "queued", "Calls waiting for a bulkhead slot")))(e)
+	}
+
+	encode := handler.Encode
+
+	if fallback := routeConfig.Fallback; fallback.StaleAge.Duration > 0 || len(fallback.Upstream) > 0 || fallback.Status > 0 {
+		middleware, err := builder.fallback(handler, routeConfig)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "fallback")
+		}
+
+		e = middleware(e)
+		encode = encodeStatic(encode)
+		options = append(options, httptransport.ServerBefore(degradedBefore), httptransport.ServerAfter(degradedAfter))
+	}
+
+	// Middlewares are applied in the listed order, so the first one is the innermost.
+	for _, name := range routeConfig.Middleware {
+		factory, ok := builder.middlewares[name]
+
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown middleware %q", name))
+		}
+
+		middleware, err := factory(routeConfig)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "middleware %s", name)
+		}
+
+		e = middleware(e)
+	}
+
+	rewriter, err := NewRewriter(routeConfig.Rewrite)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options = append(options, httptransport.ServerBefore(idempotencyKeyBefore))
+
+	var httpHandler http.Handler = httptransport.NewServer(e, handler.Decode, encode, options...)
+
+	if rewriter != nil {
+		httpHandler = rewriteHandler(rewriter, httpHandler)
+	}
+
+	route := &Route{
+		Name:    routeConfig.Name,
+		Path:    routeConfig.Path,
+		Prefix:  routeConfig.Prefix,
+		Methods: routeConfig.Methods,
+		Hosts:   routeConfig.Hosts,
+		Headers: routeConfig.Headers,
+		Query:   routeConfig.Query,
+		Handler: httpHandler,
+	}
+
+	if versioner != nil {
+		route.Versions = versioner.Names()
+		route.Handler = versioner.Handler(httpHandler)
+	}
+
+	if routeConfig.Auth.Required {
+		authenticator, err := NewAuthenticator(builder.tokenService, builder.apiKeys, routeConfig.Auth)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "auth")
+		}
+
+		route.Handler = authenticator.Handler(route.Handler)
+	} else {
+		route.Handler = stripIdentityHandler(route.Handler)
+	}
+
+	// Shedding comes first, so that rejected requests cost as little as possible
+	if builder.shedder != nil {
+		route.Handler = builder.shedder.Handler(routeConfig.Priority, route.Handler)
+	}
+
+	return route, nil
+}
+
+// makeEndpoint builds the endpoint a route forwards to before route middlewares are applied.
+func (builder *Builder) makeEndpoint(handler Handler, routeConfig RouteConfig) (endpoint.Endpoint,
+	[]httptransport.ServerOption, error) {
+	var (
+		e       endpoint.Endpoint
+		options []httptransport.ServerOption
+		err     error
+	)
+
+	// Split routes send traffic to the variant upstreams instead of their own one
+	if len(routeConfig.Split) > 0 {
+		splitter, err := NewSplitter(routeConfig.Split, routeConfig.Sticky, func(upstream string) (endpoint.Endpoint, error) {
+			variantConfig := routeConfig
+			variantConfig.Upstream = upstream
+
+			return builder.forward(handler, variantConfig)
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		e = splitter.Endpoint()
+		options = append(options, httptransport.ServerBefore(splitter.Before))
+	} else if e, err = builder.forward(handler, routeConfig); err != nil {
+		return nil, nil, err
+	}
+
+	if len(routeConfig.Mirror.Upstream) > 0 {
+		if e, err = builder.mirror(handler, routeConfig, e); err != nil {
+			return nil, nil, errors.Wrap(err, "mirror")
+		}
+	}
+
+	return e, options, nil
+}
+
+// forward makes the endpoint calling the route upstream with the resilience policies of the route.
+func (builder *Builder) forward(handler Handler, routeConfig RouteConfig) (endpoint.Endpoint, error) {
+	e, err := handler.MakeEndpoint(routeConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if routeConfig.Timeout.Response.Duration > 0 {
+		e = TimeoutMiddleware(routeConfig.Timeout.Response.Duration)(e)
+	}
+
+	if routeConfig.Hedge.Delay.Duration > 0 || routeConfig.Hedge.Percentile != 0 {
+		middleware, err := builder.hedge(handler, routeConfig)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "hedge")
+		}
+
+		e = middleware(e)
+	}
+
+	if routeConfig.Retry.MaxAttempts > 1 {
+		e = builder.retry(handler, routeConfig)(e)
+	}
+
+	return TimeoutMiddleware(routeConfig.Timeout.Total.Duration)(e), nil
+}
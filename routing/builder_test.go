@@ -0,0 +1,90 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestBuilder(routes ...RouteConfig) *Builder {
+	builder := &Builder{
+		config:      &TomlConfig{Routes: routes},
+		logger:      log.NewNopLogger(),
+		handlers:    make(map[string]Handler),
+		middlewares: make(map[string]MiddlewareFactory),
+		counters:    make(map[string]*kitprometheus.Counter),
+		gauges:      make(map[string]*kitprometheus.Gauge),
+	}
+
+	builder.Handle("echo", Handler{
+		MakeEndpoint: func(RouteConfig) (endpoint.Endpoint, error) {
+			return func(_ context.Context, request interface{}) (interface{}, error) {
+				return request, nil
+			}, nil
+		},
+		Decode: func(context.Context, *http.Request) (interface{}, error) {
+			return struct{}{}, nil
+		},
+		Encode:     httptransport.EncodeJSONResponse,
+		Idempotent: always,
+	})
+
+	return builder
+}
+
+func TestBuildRejectsRouteNamesUnfitForMetrics(t *testing.T) {
+	for _, name := range []string{"", "user-profile", "v1.orders", "api/orders", "1st"} {
+		_, err := newTestBuilder(RouteConfig{Name: name, Path: "/", Handler: "echo"}).Build()
+
+		if err == nil || !strings.Contains(err.Error(), "route name") {
+			t.Errorf("route name %q: got %v", name, err)
+		}
+	}
+}
+
+func TestBuildRejectsDuplicateRouteNames(t *testing.T) {
+	_, err := newTestBuilder(
+		RouteConfig{Name: "orders", Path: "/orders", Handler: "echo"},
+		RouteConfig{Name: "orders", Path: "/v2/orders", Handler: "echo"},
+	).Build()
+
+	if err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestBuildAcceptsRouteNames(t *testing.T) {
+	router, err := newTestBuilder(
+		RouteConfig{Name: "orders", Path: "/orders", Handler: "echo"},
+		RouteConfig{Name: "_internal_v2", Path: "/internal", Handler: "echo"},
+	).Build()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(router.Routes()) != 2 {
+		t.Errorf("%d routes built, want 2", len(router.Routes()))
+	}
+}
+
+func TestBuildRejectsStaleRepliesOfUncachedHandlers(t *testing.T) {
+	_, err := newTestBuilder(RouteConfig{
+		Name:     "orders",
+		Path:     "/orders",
+		Handler:  "echo",
+		Fallback: FallbackConfig{StaleAge: Duration{Duration: time.Minute}},
+	}).Build()
+
+	if err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Errorf("got %v", err)
+	}
+}
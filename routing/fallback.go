@@ -0,0 +1,154 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+	. "api-gateway/middleware"
+
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+const (
+	defaultFallbackMaxEntries   = 1000
+	defaultFallbackMaxBodyBytes = 1 << 20
+)
+
+// fallback makes the fallback middleware of the route, the alternate upstream
+// is called with the resilience policies of the route.
+func (builder *Builder) fallback(handler Handler, routeConfig RouteConfig) (endpoint.Middleware, error) {
+	config := routeConfig.Fallback
+
+	if config.StaleAge.Duration > 0 && handler.CacheKey == nil {
+		return nil, errors.New(fmt.Sprintf("handler %s replies can not be served stale", routeConfig.Handler))
+	}
+
+	policy := FallbackPolicy{
+		StaleAge:     config.StaleAge.Duration,
+		MaxBodyBytes: config.MaxBodyBytes,
+		MaxEntries:   config.MaxEntries,
+		CacheKey:     handler.CacheKey,
+	}
+
+	if policy.MaxBodyBytes <= 0 {
+		policy.MaxBodyBytes = defaultFallbackMaxBodyBytes
+	}
+
+	if policy.MaxEntries <= 0 {
+		policy.MaxEntries = defaultFallbackMaxEntries
+	}
+
+	if len(config.Upstream) > 0 {
+		alternateConfig := routeConfig
+		alternateConfig.Upstream = config.Upstream
+		alternate, err := builder.forward(handler, alternateConfig)
+
+		if err != nil {
+			return nil, err
+		}
+
+		policy.Alternate = alternate
+	}
+
+	if config.Status > 0 {
+		policy.Static = &StaticResponse{
+			StatusCode: config.Status,
+			Header:     http.Header{},
+			Body:       []byte(config.Body),
+		}
+
+		if len(config.ContentType) > 0 {
+			policy.Static.Header.Set("Content-Type", config.ContentType)
+		}
+	}
+
+	fallbacks := builder.routeCounter(routeConfig.Name, "fallback", "Replies served by a fallback", "kind")
+
+	return FallbackMiddleware(policy, fallbacks), nil
+}
+
+// encodeStatic writes static fallbacks as they are and leaves other responses to encode.
+func encodeStatic(encode httptransport.EncodeResponseFunc) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		static, ok := response.(StaticResponse)
+
+		if !ok {
+			return encode(ctx, w, response)
+		}
+
+		for name, values := range static.Header {
+			w.Header()[name] = values
+		}
+
+		w.WriteHeader(static.StatusCode)
+		_, err := w.Write(static.Body)
+
+		return err
+	}
+}
+
+func degradedBefore(ctx context.Context, _ *http.Request) context.Context {
+	return WithDegraded(ctx)
+}
+
+// degradedAfter marks replies served by a fallback before they are encoded.
+func degradedAfter(ctx context.Context, w http.ResponseWriter) context.Context {
+	if kind := Degraded(ctx); len(kind) > 0 {
+		w.Header().Set(DegradedHeader, kind)
+	}
+
+	return ctx
+}
+
+// proxyCacheKey keys proxied GET and HEAD requests by their version, variant, URL and credentials.
+func proxyCacheKey(ctx context.Context, request interface{}) (string, bool) {
+	proxyRequest := request.(ProxyRequest)
+
+	if proxyRequest.Method != http.MethodGet && proxyRequest.Method != http.MethodHead {
+		return "", false
+	}
+
+	path := proxyRequest.Path
+
+	if len(proxyRequest.RawPath) > 0 {
+		path = proxyRequest.RawPath
+	}
+
+	return upstreamChoice(ctx) + proxyRequest.Method + " " + proxyRequest.Host + path + "?" +
+		proxyRequest.RawQuery + "\n" + credentials(proxyRequest.Header), true
+}
+
+// aggregateCacheKey keys aggregate requests by their version, variant, query and credentials.
+func aggregateCacheKey(ctx context.Context, request interface{}) (string, bool) {
+	aggregateRequest := request.(AggregateRequest)
+
+	return upstreamChoice(ctx) + aggregateRequest.Query.Encode() + "\n" + credentials(aggregateRequest.Header), true
+}
+
+// upstreamChoice tells apart requests of one route that versions or variants send to different upstreams.
+func upstreamChoice(ctx context.Context) string {
+	return Version(ctx) + "\n" + Variant(ctx) + "\n"
+}
+
+// credentialsHeaders tell whose reply a request gets. API keys are not among them as
+// the authenticator removes them, the identity it verified tells their owners apart.
+var credentialsHeaders = []string{"Authorization", "Cookie", SubjectHeader, ScopesHeader}
+
+// credentials hashes the credentials of a request, so that clients never share cached replies.
+func credentials(header http.Header) string {
+	hash := sha256.New()
+
+	for _, name := range credentialsHeaders {
+		for _, value := range header[http.CanonicalHeaderKey(name)] {
+			hash.Write([]byte(name + ": " + value + "\n"))
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,171 @@
+package routing
+
+import (
+	. "api-gateway/data"
+	. "api-gateway/middleware"
+
+	"context"
+	"errors"
+	"github.com/go-kit/kit/metrics/discard"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyRequest(header http.Header) ProxyRequest {
+	return ProxyRequest{
+		Method:   http.MethodGet,
+		Host:     "api.example.com",
+		Path:     "/profile",
+		RawQuery: "fields=all",
+		Header:   header,
+	}
+}
+
+func TestProxyCacheKeySeparatesClients(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  http.Header
+		equal bool
+	}{
+		{"same cookie", http.Header{"Cookie": {"session=a"}}, http.Header{"Cookie": {"session=a"}}, true},
+		{"different cookies", http.Header{"Cookie": {"session=a"}}, http.Header{"Cookie": {"session=b"}}, false},
+		{"different tokens", http.Header{"Authorization": {"Bearer a"}}, http.Header{"Authorization": {"Bearer b"}}, false},
+		{"different subjects", http.Header{SubjectHeader: {"alice"}}, http.Header{SubjectHeader: {"bob"}}, false},
+		{"different scopes", http.Header{SubjectHeader: {"alice"}, ScopesHeader: {"read"}},
+			http.Header{SubjectHeader: {"alice"}, ScopesHeader: {"read write"}}, false},
+		{"anonymous and authenticated", http.Header{}, http.Header{"Cookie": {"session=a"}}, false},
+	}
+
+	for _, test := range tests {
+		a, ok := proxyCacheKey(context.Background(), proxyRequest(test.a))
+
+		if !ok {
+			t.Fatalf("%s: GET request is not cached", test.name)
+		}
+
+		b, _ := proxyCacheKey(context.Background(), proxyRequest(test.b))
+
+		if (a == b) != test.equal {
+			t.Errorf("%s: keys equal %v, want %v", test.name, a == b, test.equal)
+		}
+	}
+}
+
+func TestProxyCacheKeySeparatesUpstreams(t *testing.T) {
+	ctx := context.Background()
+	request := proxyRequest(http.Header{})
+	plain, _ := proxyCacheKey(ctx, request)
+
+	keys := map[string]string{"no version or variant": plain}
+	contexts := map[string]context.Context{
+		"version v2":     WithVersion(ctx, "v2"),
+		"variant canary": WithVariant(ctx, "canary"),
+		"both":           WithVariant(WithVersion(ctx, "v2"), "canary"),
+	}
+
+	for name, ctx := range contexts {
+		key, _ := proxyCacheKey(ctx, request)
+
+		for other, otherKey := range keys {
+			if key == otherKey {
+				t.Errorf("%s shares a cache key with %s", name, other)
+			}
+		}
+
+		keys[name] = key
+	}
+
+	escaped := request
+	escaped.Path, escaped.RawPath = "/a/b", "/a%2Fb"
+	nested := request
+	nested.Path = "/a/b"
+
+	a, _ := proxyCacheKey(ctx, escaped)
+	b, _ := proxyCacheKey(ctx, nested)
+
+	if a == b {
+		t.Error("an escaped slash shares a cache key with a path separator")
+	}
+}
+
+func TestAggregateCacheKeySeparatesVersions(t *testing.T) {
+	request := AggregateRequest{Query: url.Values{"id": {"1"}}, Header: http.Header{}}
+	a, _ := aggregateCacheKey(WithVersion(context.Background(), "v1"), request)
+	b, _ := aggregateCacheKey(WithVersion(context.Background(), "v2"), request)
+
+	if a == b {
+		t.Error("requests of different versions share a cache key")
+	}
+}
+
+func TestAggregateCacheKeySeparatesCookies(t *testing.T) {
+	query := url.Values{"id": {"1"}}
+	a, _ := aggregateCacheKey(context.Background(), AggregateRequest{Query: query, Header: http.Header{"Cookie": {"session=a"}}})
+	b, _ := aggregateCacheKey(context.Background(), AggregateRequest{Query: query, Header: http.Header{"Cookie": {"session=b"}}})
+
+	if a == b {
+		t.Error("requests with different cookies share a cache key")
+	}
+}
+
+func TestProxyCacheKeySkipsUnsafeMethods(t *testing.T) {
+	request := proxyRequest(http.Header{})
+	request.Method = http.MethodPost
+
+	if _, ok := proxyCacheKey(context.Background(), request); ok {
+		t.Error("POST request is cached")
+	}
+}
+
+func TestStaleReplyIsNotServedToAnotherCookie(t *testing.T) {
+	failing := false
+	upstream := func(ctx context.Context, request interface{}) (interface{}, error) {
+		if failing {
+			return nil, errors.New("upstream down")
+		}
+
+		cookie := request.(ProxyRequest).Header.Get("Cookie")
+
+		return ProxyResponse{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("profile of " + cookie)),
+		}, nil
+	}
+
+	e := FallbackMiddleware(FallbackPolicy{
+		StaleAge:     time.Minute,
+		MaxBodyBytes: 1024,
+		MaxEntries:   10,
+		CacheKey:     proxyCacheKey,
+	}, discard.NewCounter())(upstream)
+
+	response, err := e(context.Background(), proxyRequest(http.Header{"Cookie": {"session=a"}}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.ReadAll(response.(ProxyResponse).Body)
+	response.(ProxyResponse).Body.Close()
+	failing = true
+
+	if response, err := e(context.Background(), proxyRequest(http.Header{"Cookie": {"session=b"}})); err == nil {
+		body, _ := ioutil.ReadAll(response.(ProxyResponse).Body)
+		t.Fatalf("client b got %q", body)
+	}
+
+	response, err = e(context.Background(), proxyRequest(http.Header{"Cookie": {"session=a"}}))
+
+	if err != nil {
+		t.Fatalf("client a got no stale reply: %v", err)
+	}
+
+	if body, _ := ioutil.ReadAll(response.(ProxyResponse).Body); string(body) != "profile of session=a" {
+		t.Errorf("client a got %q", body)
+	}
+}
@@ -0,0 +1,269 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+	. "api-gateway/endpoints"
+	. "api-gateway/transports"
+	"api-gateway/upstreams"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+const (
+	IssueTokenHandler   = "issueToken"
+	VerifyTokenHandler  = "verifyToken"
+	RevokeTokenHandler  = "revokeToken"
+	HealthHandler       = "health"
+	ProxyHandler        = "proxy"
+	AggregateHandler    = "aggregate"
+	OutliersHandler     = "outliers"
+	CreateAPIKeyHandler = "createAPIKey"
+	ListAPIKeysHandler  = "listAPIKeys"
+	RotateAPIKeyHandler = "rotateAPIKey"
+	RevokeAPIKeyHandler = "revokeAPIKey"
+	IntrospectHandler   = "introspect"
+)
+
+func (builder *Builder) registerHandlers() {
+	builder.Handle(IssueTokenHandler, Handler{
+		MakeEndpoint: builder.tokenEndpoint(builder.config.TokenService.IssueTokenPath, MakeProxyIssueTokenEndpoint),
+		Decode:       DecodeIssueTokenRequest,
+		Encode:       EncodeResponse,
+		Idempotent:   never,
+	})
+
+	builder.Handle(VerifyTokenHandler, Handler{
+		MakeEndpoint: builder.tokenEndpoint(builder.config.TokenService.VerifyTokenPath, MakeProxyVerifyTokenEndpoint),
+		Decode:       DecodeVerifyTokenRequest,
+		Encode:       EncodeResponse,
+		Idempotent:   always,
+	})
+
+	builder.Handle(RevokeTokenHandler, Handler{
+		MakeEndpoint: builder.revokeEndpoint(builder.tokenEndpoint(builder.config.TokenService.RevokeTokenPath, MakeProxyRevokeTokenEndpoint)),
+		Decode:       DecodeRevokeTokenRequest,
+		Encode:       EncodeResponse,
+		Idempotent:   never,
+	})
+
+	builder.Handle(HealthHandler, Handler{
+		MakeEndpoint: func(route RouteConfig) (endpoint.Endpoint, error) {
+			return MakeHealthCheckEndpoint(builder.tokenService, builder.upstreams.Health), nil
+		},
+		Decode:     DecodeHealthRequest,
+		Encode:     httptransport.EncodeJSONResponse,
+		Idempotent: always,
+	})
+
+	builder.Handle(OutliersHandler, Handler{
+		MakeEndpoint: func(route RouteConfig) (endpoint.Endpoint, error) {
+			return MakeOutliersEndpoint(builder.upstreams.Ejections), nil
+		},
+		Decode:     DecodeOutliersRequest,
+		Encode:     httptransport.EncodeJSONResponse,
+		Idempotent: always,
+	})
+
+	builder.Handle(CreateAPIKeyHandler, Handler{
+		MakeEndpoint: builder.apiKeyEndpoint(MakeCreateAPIKeyEndpoint),
+		Decode:       DecodeCreateAPIKeyRequest,
+		Encode:       httptransport.EncodeJSONResponse,
+		Idempotent:   never,
+	})
+
+	builder.Handle(ListAPIKeysHandler, Handler{
+		MakeEndpoint: builder.apiKeyEndpoint(MakeListAPIKeysEndpoint),
+		Decode:       DecodeListAPIKeysRequest,
+		Encode:       httptransport.EncodeJSONResponse,
+		Idempotent:   always,
+	})
+
+	builder.Handle(RotateAPIKeyHandler, Handler{
+		MakeEndpoint: builder.apiKeyEndpoint(MakeRotateAPIKeyEndpoint),
+		Decode:       DecodeRotateAPIKeyRequest,
+		Encode:       httptransport.EncodeJSONResponse,
+		Idempotent:   never,
+	})
+
+	builder.Handle(RevokeAPIKeyHandler, Handler{
+		MakeEndpoint: builder.apiKeyEndpoint(MakeRevokeAPIKeyEndpoint),
+		Decode:       DecodeRevokeAPIKeyRequest,
+		Encode:       httptransport.EncodeJSONResponse,
+		Idempotent:   always,
+	})
+
+	builder.Handle(IntrospectHandler, Handler{
+		MakeEndpoint: func(route RouteConfig) (endpoint.Endpoint, error) {
+			// Only known clients may learn about the tokens of others
+			if !route.Auth.Required {
+				return nil, errors.New("introspection route must require authentication")
+			}
+
+			return MakeIntrospectionEndpoint(builder.tokenService, builder.apiKeys), nil
+		},
+		Decode:     DecodeIntrospectionRequest,
+		Encode:     EncodeIntrospectionResponse,
+		Idempotent: always,
+	})
+
+	builder.Handle(ProxyHandler, Handler{
+		MakeEndpoint: func(route RouteConfig) (endpoint.Endpoint, error) {
+			cluster, err := builder.upstreams.Get(route.Upstream)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return cluster.Endpoint("", MakePassthroughEndpoint), nil
+		},
+		Decode:     DecodeProxyRequest,
+		Encode:     EncodeProxyResponse,
+		Idempotent: idempotentMethod,
+		CacheKey:   proxyCacheKey,
+	})
+
+	builder.Handle(AggregateHandler, Handler{
+		MakeEndpoint: builder.aggregateEndpoint,
+		Decode:       DecodeAggregateRequest,
+		Encode:       EncodeResponse,
+		Idempotent:   always,
+		CacheKey:     aggregateCacheKey,
+	})
+}
+
+// aggregateEndpoint fans out to the route calls, calls without an upstream use the one of the route.
+func (builder *Builder) aggregateEndpoint(route RouteConfig) (endpoint.Endpoint, error) {
+	if len(route.Calls) == 0 {
+		return nil, errors.New("aggregate route has no calls")
+	}
+
+	calls := make([]AggregateCall, 0, len(route.Calls))
+
+	for _, callConfig := range route.Calls {
+		name := callConfig.Upstream
+
+		if len(name) == 0 {
+			name = route.Upstream
+		}
+
+		cluster, err := builder.upstreams.Get(name)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "call %s", callConfig.Name)
+		}
+
+		method := callConfig.Method
+
+		if len(method) == 0 {
+			method = http.MethodGet
+		}
+
+		calls = append(calls, AggregateCall{
+			Name:     callConfig.Name,
+			Method:   method,
+			Path:     callConfig.Path,
+			Timeout:  callConfig.Timeout.Duration,
+			Optional: callConfig.Optional,
+			Endpoint: cluster.Endpoint("", MakePassthroughEndpoint),
+		})
+	}
+
+	return MakeAggregateEndpoint(calls)
+}
+
+// apiKeyEndpoint makes an endpoint managing the keys of the service set with UseAPIKeys,
+// only routes requiring authenticated callers with scopes may manage keys.
+func (builder *Builder) apiKeyEndpoint(factory func(APIKeyService) endpoint.Endpoint) func(RouteConfig) (endpoint.Endpoint, error) {
+	return func(route RouteConfig) (endpoint.Endpoint, error) {
+		if builder.apiKeys == nil {
+			return nil, errors.New("api keys are not enabled")
+		}
+
+		// Whoever can manage keys can let anyone in
+		if !route.Auth.Required || len(route.Auth.Scopes) == 0 {
+			return nil, errors.New("api key routes must require authentication with an admin scope")
+		}
+
+		return factory(builder.apiKeys), nil
+	}
+}
+
+// tokenEndpoint proxies a token API call to the route upstream,
+// routes without an upstream use the one of the token service.
+// When the route rewrites paths the call goes to its rewritten path instead of the configured one.
+func (builder *Builder) tokenEndpoint(path string, factory upstreams.EndpointFactory) func(RouteConfig) (endpoint.Endpoint, error) {
+	return func(route RouteConfig) (endpoint.Endpoint, error) {
+		rewriter, err := NewRewriter(route.Rewrite)
+
+		if err != nil {
+			return nil, err
+		}
+
+		upstreamPath := path
+
+		if rewriter != nil && len(route.Path) > 0 {
+			upstreamPath = rewriter.Rewrite(route.Path)
+		}
+
+		name := route.Upstream
+
+		if len(name) == 0 {
+			name = builder.config.TokenService.Upstream
+		}
+
+		cluster, err := builder.upstreams.Get(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return cluster.Endpoint(upstreamPath, factory), nil
+	}
+}
+
+// revokeEndpoint tells the listeners registered with OnTokenRevoked about revoked tokens.
+// Calls that failed may still have revoked the token, so only explicit rejections are not told.
+func (builder *Builder) revokeEndpoint(makeEndpoint func(RouteConfig) (endpoint.Endpoint, error)) func(RouteConfig) (endpoint.Endpoint, error) {
+	return func(route RouteConfig) (endpoint.Endpoint, error) {
+		next, err := makeEndpoint(route)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			response, err := next(ctx, request)
+
+			if revoked, ok := response.(RevokeTokenResponse); !ok || len(revoked.Error) == 0 {
+				for _, listener := range builder.revoked {
+					listener(request.(RevokeTokenRequest).Token)
+				}
+			}
+
+			return response, err
+		}, nil
+	}
+}
+
+func always(interface{}) bool {
+	return true
+}
+
+func never(interface{}) bool {
+	return false
+}
+
+// idempotentMethod tells whether the method of a proxied request is idempotent as defined by RFC 7231.
+func idempotentMethod(request interface{}) bool {
+	switch request.(ProxyRequest).Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHedgeMaxPercent   = 10
+	defaultHedgeMaxBodyBytes = 64 << 10
+)
+
+// hedge makes the hedging middleware of the route, the metric is shared by the route variants.
+func (builder *Builder) hedge(handler Handler, routeConfig RouteConfig) (endpoint.Middleware, error) {
+	config := routeConfig.Hedge
+
+	if config.Percentile < 0 || config.Percentile > 100 {
+		return nil, errors.New(fmt.Sprintf("hedge percentile %v is not between 0 and 100", config.Percentile))
+	}
+	policy := HedgePolicy{
+		Delay:        config.Delay.Duration,
+		Percentile:   config.Percentile,
+		MaxPercent:   config.MaxPercent,
+		MaxBodyBytes: config.MaxBodyBytes,
+		Idempotent:   handler.Idempotent,
+	}
+
+	if policy.MaxPercent <= 0 {
+		policy.MaxPercent = defaultHedgeMaxPercent
+	}
+
+	if policy.MaxBodyBytes <= 0 {
+		policy.MaxBodyBytes = defaultHedgeMaxBodyBytes
+	}
+
+	hedges := builder.routeCounter(routeConfig.Name, "hedge", "Hedged upstream calls", "upstream", "result")
+
+	return HedgeMiddleware(policy, hedges.With("upstream", routeConfig.Upstream)), nil
+}
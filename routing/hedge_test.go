@@ -0,0 +1,19 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"testing"
+)
+
+func TestHedgeRejectsPercentilesOutOfRange(t *testing.T) {
+	builder := &Builder{}
+
+	for _, percentile := range []float64{-1, 100.5, 150} {
+		routeConfig := RouteConfig{Name: "hedged", Hedge: HedgeConfig{Percentile: percentile}}
+
+		if _, err := builder.hedge(Handler{}, routeConfig); err == nil {
+			t.Errorf("percentile %v is accepted", percentile)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package routing
+
+import (
+	"fmt"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// routeCounter registers the counter of a route once, variants of the route share it.
+func (builder *Builder) routeCounter(route, name, help string, labels ...string) *kitprometheus.Counter {
+	key := fmt.Sprintf("%s_%s_counter", route, name)
+
+	if counter, ok := builder.counters[key]; ok {
+		return counter
+	}
+
+	counter := kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Name:      key,
+			Subsystem: builder.config.Main.ServiceName,
+			Help:      fmt.Sprintf("Route %s: %s", route, help),
+		},
+		labels)
+	builder.counters[key] = counter
+
+	return counter
+}
+
+// routeGauge registers the gauge of a route once.
+func (builder *Builder) routeGauge(route, name, help string, labels ...string) *kitprometheus.Gauge {
+	key := fmt.Sprintf("%s_%s_gauge", route, name)
+
+	if gauge, ok := builder.gauges[key]; ok {
+		return gauge
+	}
+
+	gauge := kitprometheus.NewGaugeFrom(
+		stdprometheus.GaugeOpts{
+			Name:      key,
+			Subsystem: builder.config.Main.ServiceName,
+			Help:      fmt.Sprintf("Route %s: %s", route, help),
+		},
+		labels)
+	builder.gauges[key] = gauge
+
+	return gauge
+}
+
+// buildLoadShedder starts the load shedder of the gateway if it is enabled.
+func (builder *Builder) buildLoadShedder() error {
+	if builder.shedder != nil {
+		return nil
+	}
+
+	config := builder.config.LoadShedding
+
+	if config.MaxSchedulingDelay.Duration <= 0 && config.MaxGoroutines <= 0 {
+		return nil
+	}
+
+	shed := kitprometheus.NewCounterFrom(
+		stdprometheus.CounterOpts{
+			Name:      "shed_counter",
+			Subsystem: builder.config.Main.ServiceName,
+			Help:      "Requests shed under overload by priority class",
+		},
+		[]string{"class"})
+	load := kitprometheus.NewGaugeFrom(
+		stdprometheus.GaugeOpts{
+			Name:      "load_pressure_gauge",
+			Subsystem: builder.config.Main.ServiceName,
+			Help:      "Load of the gateway relative to its shedding limits",
+		},
+		[]string{})
+
+	shedder, err := NewLoadShedder(config, shed, load)
+
+	if err != nil {
+		return err
+	}
+
+	builder.shedder = shedder
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	. "github.com/go-kit/kit/ratelimit"
+	"github.com/pkg/errors"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	LoggingMiddlewareName   = "logging"
+	RateLimitMiddlewareName = "ratelimit"
+	MetricsMiddlewareName   = "metrics"
+)
+
+func (builder *Builder) registerMiddlewares() {
+	builder.Use(LoggingMiddlewareName, func(route RouteConfig) (endpoint.Middleware, error) {
+		return LoggingMiddleware(log.With(builder.logger, "route", route.Name),
+			fmt.Sprintf("%sEndpoint", route.Name)), nil
+	})
+
+	builder.Use(RateLimitMiddlewareName, func(route RouteConfig) (endpoint.Middleware, error) {
+		if route.RateLimit <= 0 {
+			return nil, errors.New("rate limit must be positive")
+		}
+
+		burst := route.RateBurst
+
+		if burst <= 0 {
+			burst = 1
+		}
+
+		return NewErroringLimiter(rate.NewLimiter(rate.Limit(route.RateLimit), burst)), nil
+	})
+
+	builder.Use(MetricsMiddlewareName, func(route RouteConfig) (endpoint.Middleware, error) {
+		counter := kitprometheus.NewCounterFrom(
+			stdprometheus.CounterOpts{
+				Name:      fmt.Sprintf("%s_counter", route.Name),
+				Subsystem: builder.config.Main.ServiceName,
+				Help:      fmt.Sprintf("Route %s counter", route.Name),
+			},
+			MetricsLabels)
+		histogram := kitprometheus.NewHistogramFrom(
+			stdprometheus.HistogramOpts{
+				Name:      fmt.Sprintf("%s_histogram", route.Name),
+				Subsystem: builder.config.Main.ServiceName,
+				Help:      fmt.Sprintf("Route %s histogram", route.Name),
+			},
+			MetricsLabels)
+
+		return MetricsMiddleware(counter, histogram, route.Name), nil
+	})
+}
@@ -0,0 +1,46 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"time"
+)
+
+const (
+	defaultMirrorTimeout      = 5 * time.Second
+	defaultMirrorMaxBodyBytes = 1 << 20
+)
+
+// mirror shadows the traffic of the route to its mirror upstream.
+func (builder *Builder) mirror(handler Handler, routeConfig RouteConfig, e endpoint.Endpoint) (endpoint.Endpoint, error) {
+	shadowConfig := routeConfig
+	shadowConfig.Upstream = routeConfig.Mirror.Upstream
+	shadow, err := handler.MakeEndpoint(shadowConfig)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options := MirrorOptions{
+		Percent:      routeConfig.Mirror.Percent,
+		Timeout:      routeConfig.Mirror.Timeout.Duration,
+		MaxBodyBytes: routeConfig.Mirror.MaxBodyBytes,
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = defaultMirrorTimeout
+	}
+
+	if options.MaxBodyBytes <= 0 {
+		options.MaxBodyBytes = defaultMirrorMaxBodyBytes
+	}
+
+	results := builder.routeCounter(routeConfig.Name, "mirror", "Mirrored requests by comparison result", "result")
+
+	logger := log.With(builder.logger, "route", routeConfig.Name, "mirror", routeConfig.Mirror.Upstream)
+
+	return MirrorMiddleware(shadow, options, results, logger)(e), nil
+}
@@ -0,0 +1,73 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryBaseBackoff  = 25 * time.Millisecond
+	defaultRetryMaxBackoff   = time.Second
+	defaultRetryMaxBodyBytes = 1 << 20
+)
+
+var (
+	defaultRetryableStatusCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+	defaultRetryOn              = []string{RetryOnConnect, RetryOnTimeout, RetryOnReset}
+)
+
+// retry makes the retry middleware of the route, the metric is shared by the route variants.
+func (builder *Builder) retry(handler Handler, routeConfig RouteConfig) endpoint.Middleware {
+	config := routeConfig.Retry
+	policy := RetryPolicy{
+		MaxAttempts:          config.MaxAttempts,
+		PerTryTimeout:        config.PerTryTimeout.Duration,
+		BaseBackoff:          config.BaseBackoff.Duration,
+		MaxBackoff:           config.MaxBackoff.Duration,
+		RetryableStatusCodes: config.RetryableStatusCodes,
+		RetryOn:              config.RetryOn,
+		NonIdempotent:        config.NonIdempotent,
+		MaxBodyBytes:         config.MaxBodyBytes,
+		Idempotent:           handler.Idempotent,
+	}
+
+	if policy.BaseBackoff <= 0 {
+		policy.BaseBackoff = defaultRetryBaseBackoff
+	}
+
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = defaultRetryMaxBackoff
+	}
+
+	if policy.RetryableStatusCodes == nil {
+		policy.RetryableStatusCodes = defaultRetryableStatusCodes
+	}
+
+	if policy.RetryOn == nil {
+		policy.RetryOn = defaultRetryOn
+	}
+
+	if policy.MaxBodyBytes <= 0 {
+		policy.MaxBodyBytes = defaultRetryMaxBodyBytes
+	}
+
+	retries := builder.routeCounter(routeConfig.Name, "retry", "Retried upstream calls", "upstream")
+
+	return RetryMiddleware(policy, retries.With("upstream", routeConfig.Upstream),
+		log.With(builder.logger, "route", routeConfig.Name, "upstream", routeConfig.Upstream))
+}
+
+// idempotencyKeyBefore keeps the idempotency key of the request for retries and upstream calls.
+func idempotencyKeyBefore(ctx context.Context, r *http.Request) context.Context {
+	if key := r.Header.Get(IdempotencyKeyHeader); len(key) > 0 {
+		return WithIdempotencyKey(ctx, key)
+	}
+
+	return ctx
+}
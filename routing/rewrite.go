@@ -0,0 +1,103 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ForwardedPrefixHeader keeps the path the client requested when it was rewritten.
+const ForwardedPrefixHeader = "X-Forwarded-Prefix"
+
+// Rewriter applies the rewrite rules of a route to request paths.
+type Rewriter struct {
+	stripPrefix string
+	addPrefix   string
+	regex       *regexp.Regexp
+	replacement string
+}
+
+// NewRewriter compiles the rewrite rules, it returns nil when there are none.
+func NewRewriter(config RewriteConfig) (*Rewriter, error) {
+	if config == (RewriteConfig{}) {
+		return nil, nil
+	}
+
+	rewriter := &Rewriter{
+		stripPrefix: config.StripPrefix,
+		addPrefix:   config.AddPrefix,
+		replacement: config.Replacement,
+	}
+
+	if len(config.Regex) > 0 {
+		regex, err := regexp.Compile(config.Regex)
+
+		if err != nil {
+			return nil, errors.Wrap(err, "rewrite regex")
+		}
+
+		rewriter.regex = regex
+	}
+
+	return rewriter, nil
+}
+
+// Rewrite strips, replaces and adds to requestPath. Prefixes are stripped by whole segments,
+// so that "/api" is stripped from "/api/orders" but not from "/apiary".
+func (rewriter *Rewriter) Rewrite(requestPath string) string {
+	if rewriter.stripsPrefixOf(requestPath) {
+		requestPath = requestPath[len(rewriter.stripPrefix):]
+
+		if !strings.HasPrefix(requestPath, "/") {
+			requestPath = "/" + requestPath
+		}
+	}
+
+	if rewriter.regex != nil {
+		requestPath = rewriter.regex.ReplaceAllString(requestPath, rewriter.replacement)
+	}
+
+	// Dot segments of clients are not resolved, so that they can not climb out of the prefix
+	if len(rewriter.addPrefix) > 0 {
+		requestPath = strings.TrimSuffix(rewriter.addPrefix, "/") + "/" + strings.TrimPrefix(requestPath, "/")
+	}
+
+	return requestPath
+}
+
+func (rewriter *Rewriter) stripsPrefixOf(requestPath string) bool {
+	prefix := rewriter.stripPrefix
+
+	if len(prefix) == 0 || !strings.HasPrefix(requestPath, prefix) {
+		return false
+	}
+
+	rest := requestPath[len(prefix):]
+
+	return len(rest) == 0 || strings.HasPrefix(rest, "/") || strings.HasSuffix(prefix, "/")
+}
+
+// rewriteHandler rewrites the path of requests before next forwards them.
+func rewriteHandler(rewriter *Rewriter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originalPath := r.URL.Path
+		rewrittenPath := rewriter.Rewrite(originalPath)
+
+		if rewrittenPath != originalPath {
+			u := *r.URL
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = &u
+			r2.URL.Path = rewrittenPath
+			r2.URL.RawPath = ""
+			r2.Header = r.Header.Clone()
+			r2.Header.Set(ForwardedPrefixHeader, originalPath)
+			r = r2
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
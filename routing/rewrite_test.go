@@ -0,0 +1,60 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		config RewriteConfig
+		path   string
+		want   string
+	}{
+		{RewriteConfig{StripPrefix: "/api"}, "/api/orders", "/orders"},
+		{RewriteConfig{StripPrefix: "/api"}, "/api", "/"},
+		{RewriteConfig{StripPrefix: "/api"}, "/apiary/x", "/apiary/x"},
+		{RewriteConfig{StripPrefix: "/api"}, "/other/api", "/other/api"},
+		{RewriteConfig{StripPrefix: "/api/"}, "/api/orders", "/orders"},
+		{RewriteConfig{StripPrefix: "/api/"}, "/api", "/api"},
+		{RewriteConfig{StripPrefix: "/api", AddPrefix: "/v2"}, "/api/orders/", "/v2/orders/"},
+		{RewriteConfig{StripPrefix: "/api", AddPrefix: "/v2/"}, "/api", "/v2/"},
+		{RewriteConfig{AddPrefix: "/internal"}, "/../admin", "/internal/../admin"},
+		{RewriteConfig{Regex: "^/users/([0-9]+)$", Replacement: "/profiles/$1"}, "/users/42", "/profiles/42"},
+	}
+
+	for _, test := range tests {
+		rewriter, err := NewRewriter(test.config)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := rewriter.Rewrite(test.path); got != test.want {
+			t.Errorf("%+v: %s is rewritten to %s, want %s", test.config, test.path, got, test.want)
+		}
+	}
+}
+
+func TestRewriteHandlerKeepsOriginalRequest(t *testing.T) {
+	rewriter, _ := NewRewriter(RewriteConfig{StripPrefix: "/api"})
+	var rewritten *http.Request
+
+	handler := rewriteHandler(rewriter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rewritten = r
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/orders?id=1", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if rewritten.URL.Path != "/orders" || rewritten.URL.RawQuery != "id=1" || rewritten.Header.Get(ForwardedPrefixHeader) != "/api/orders" {
+		t.Errorf("rewritten to %s with %v", rewritten.URL, rewritten.Header)
+	}
+
+	if r.URL.Path != "/api/orders" || len(r.Header.Get(ForwardedPrefixHeader)) > 0 {
+		t.Error("original request is changed")
+	}
+}
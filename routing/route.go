@@ -0,0 +1,158 @@
+package routing
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AnyValue matches any value of a header or a query parameter that is present.
+const AnyValue = "*"
+
+// Route is a compiled entry of the route table.
+type Route struct {
+	Name    string
+	Path    string
+	Prefix  string
+	Methods []string
+	Hosts   []string
+	Headers map[string]string
+	Query   map[string]string
+	Handler http.Handler
+
+	// Versions may prefix the path of versioned routes
+	Versions []string
+}
+
+// matches reports whether the request is served by the route regardless of its method.
+func (route *Route) matches(r *http.Request) bool {
+	return route.matchPath(r.URL.Path) &&
+		route.matchHost(r.Host) &&
+		route.matchHeaders(r.Header) &&
+		route.matchQuery(r)
+}
+
+func (route *Route) matchPath(path string) bool {
+	if _, unversioned, ok := splitVersionPrefix(path, route.Versions); ok {
+		path = unversioned
+	}
+
+	if len(route.Path) > 0 {
+		return path == route.Path
+	}
+
+	return strings.HasPrefix(path, route.Prefix)
+}
+
+func (route *Route) matchHost(host string) bool {
+	if len(route.Hosts) == 0 {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+
+	for _, pattern := range route.Hosts {
+		pattern = strings.ToLower(pattern)
+
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) && len(host) > len(pattern)-1 {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (route *Route) matchHeaders(header http.Header) bool {
+	for name, value := range route.Headers {
+		if !matchValues(header[http.CanonicalHeaderKey(name)], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (route *Route) matchQuery(r *http.Request) bool {
+	if len(route.Query) == 0 {
+		return true
+	}
+
+	query := r.URL.Query()
+
+	for name, value := range route.Query {
+		if !matchValues(query[name], value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchValues(values []string, expected string) bool {
+	for _, value := range values {
+		if expected == AnyValue || value == expected {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsMethod reports whether the route accepts the method,
+// a route without methods accepts any of them.
+func (route *Route) allowsMethod(method string) bool {
+	if len(route.Methods) == 0 {
+		return true
+	}
+
+	for _, m := range route.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostRank orders routes by host: exact hosts, then wildcards, then any host.
+func (route *Route) hostRank() int {
+	rank := 0
+
+	for _, pattern := range route.Hosts {
+		if !strings.HasPrefix(pattern, "*.") {
+			return 2
+		}
+
+		rank = 1
+	}
+
+	return rank
+}
+
+// less defines route precedence: routes bound to exact hosts go before wildcard
+// hosts and before routes for any host, then exact paths go before prefixes and
+// longer prefixes go before shorter ones, then routes with more header and query
+// conditions go first. Routes that are equal keep the order of the route table.
+func (route *Route) less(other *Route) bool {
+	if route.hostRank() != other.hostRank() {
+		return route.hostRank() > other.hostRank()
+	}
+
+	if (len(route.Path) > 0) != (len(other.Path) > 0) {
+		return len(route.Path) > 0
+	}
+
+	if len(route.Path)+len(route.Prefix) != len(other.Path)+len(other.Prefix) {
+		return len(route.Path)+len(route.Prefix) > len(other.Path)+len(other.Prefix)
+	}
+
+	return len(route.Headers)+len(route.Query) > len(other.Headers)+len(other.Query)
+}
@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests to the routes built from the route table.
+type Router struct {
+	routes []*Route
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Add registers route keeping the table ordered by precedence.
+func (router *Router) Add(route *Route) {
+	router.routes = append(router.routes, route)
+	sort.SliceStable(router.routes, func(i, j int) bool {
+		return router.routes[i].less(router.routes[j])
+	})
+}
+
+func (router *Router) Routes() []*Route {
+	return router.routes
+}
+
+// ServeHTTP dispatches the request to the first matching route in precedence order.
+// When routes match everything but the method the reply is 405, otherwise it is 404.
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+
+	for _, route := range router.routes {
+		if !route.matches(r) {
+			continue
+		}
+
+		if !route.allowsMethod(r.Method) {
+			allowed = appendMissing(allowed, route.Methods)
+			continue
+		}
+
+		route.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+func appendMissing(methods []string, more []string) []string {
+	for _, method := range more {
+		method = strings.ToUpper(method)
+		found := false
+
+		for _, m := range methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
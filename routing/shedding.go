@@ -0,0 +1,171 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"fmt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Priority classes of requests, lower ones are shed first.
+const (
+	PriorityCritical = "critical"
+	PriorityNormal   = "normal"
+	PriorityBulk     = "bulk"
+)
+
+const (
+	defaultSheddingInterval = 100 * time.Millisecond
+
+	// bulkPressure is the share of the limits from which bulk requests are shed
+	bulkPressure = 0.8
+	// delayWeight is the weight of the last sample in the scheduling delay
+	delayWeight = 0.3
+)
+
+// LoadShedder rejects requests of lower priority classes while the gateway is overloaded.
+// Load is the larger of the scheduling delay of the gateway goroutines and the number
+// of goroutines relative to their limits. Bulk requests are shed from bulkPressure of
+// the limits, normal ones once a limit is reached and critical ones never.
+type LoadShedder struct {
+	config  LoadSheddingConfig
+	trusted []*net.IPNet
+	shed    metrics.Counter
+	load    metrics.Gauge
+
+	delay    time.Duration
+	pressure uint64
+}
+
+// NewLoadShedder starts sampling the load of the gateway, it returns nil when load shedding is disabled.
+func NewLoadShedder(config LoadSheddingConfig, shed metrics.Counter, load metrics.Gauge) (*LoadShedder, error) {
+	if config.MaxSchedulingDelay.Duration <= 0 && config.MaxGoroutines <= 0 {
+		return nil, nil
+	}
+
+	if config.Interval.Duration <= 0 {
+		config.Interval.Duration = defaultSheddingInterval
+	}
+
+	shedder := &LoadShedder{
+		config: config,
+		shed:   shed,
+		load:   load,
+	}
+
+	for _, cidr := range config.TrustedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "trusted network %s", cidr)
+		}
+
+		shedder.trusted = append(shedder.trusted, network)
+	}
+
+	go shedder.run()
+
+	return shedder, nil
+}
+
+// run samples the load, ticks are received late when goroutines wait to be scheduled.
+func (shedder *LoadShedder) run() {
+	ticker := time.NewTicker(shedder.config.Interval.Duration)
+	defer ticker.Stop()
+
+	for tick := range ticker.C {
+		delay := time.Since(tick)
+		shedder.delay += time.Duration(delayWeight * float64(delay-shedder.delay))
+
+		var pressure float64
+
+		if shedder.config.MaxSchedulingDelay.Duration > 0 {
+			pressure = float64(shedder.delay) / float64(shedder.config.MaxSchedulingDelay.Duration)
+		}
+
+		if shedder.config.MaxGoroutines > 0 {
+			pressure = math.Max(pressure, float64(runtime.NumGoroutine())/float64(shedder.config.MaxGoroutines))
+		}
+
+		atomic.StoreUint64(&shedder.pressure, math.Float64bits(pressure))
+		shedder.load.Set(pressure)
+	}
+}
+
+func (shedder *LoadShedder) shouldShed(priority string) bool {
+	pressure := math.Float64frombits(atomic.LoadUint64(&shedder.pressure))
+
+	switch priority {
+	case PriorityBulk:
+		return pressure >= bulkPressure
+	case PriorityNormal:
+		return pressure >= 1
+	default:
+		return false
+	}
+}
+
+// priority is the class of the route unless a trusted client asks for another one.
+func (shedder *LoadShedder) priority(r *http.Request, routePriority string) string {
+	if len(shedder.config.PriorityHeader) == 0 {
+		return routePriority
+	}
+
+	priority := r.Header.Get(shedder.config.PriorityHeader)
+
+	if validPriority(priority) != nil || !shedder.trustedClient(r) {
+		return routePriority
+	}
+
+	return priority
+}
+
+func (shedder *LoadShedder) trustedClient(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	for _, network := range shedder.trusted {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handler sheds the requests of a route with the given priority while the gateway is overloaded.
+func (shedder *LoadShedder) Handler(routePriority string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priority := shedder.priority(r, routePriority)
+
+		if shedder.shouldShed(priority) {
+			shedder.shed.With("class", priority).Add(1)
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "gateway is overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func validPriority(priority string) error {
+	switch priority {
+	case PriorityCritical, PriorityNormal, PriorityBulk:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unknown priority %q", priority))
+	}
+}
@@ -0,0 +1,120 @@
+package routing
+
+import (
+	. "api-gateway"
+
+	"github.com/go-kit/kit/metrics/discard"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestShedder makes a shedder that never samples the load, so that tests set it.
+func newTestShedder(t *testing.T, pressure float64) *LoadShedder {
+	shedder, err := NewLoadShedder(LoadSheddingConfig{
+		MaxGoroutines:   1000,
+		Interval:        Duration{Duration: time.Hour},
+		PriorityHeader:  "X-Priority",
+		TrustedNetworks: []string{"10.0.0.0/8", "::1/128"},
+	}, discard.NewCounter(), discard.NewGauge())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreUint64(&shedder.pressure, math.Float64bits(pressure))
+
+	return shedder
+}
+
+func TestShouldShedByPriority(t *testing.T) {
+	tests := []struct {
+		pressure               float64
+		critical, normal, bulk bool
+	}{
+		{0, false, false, false},
+		{0.79, false, false, false},
+		{0.8, false, false, true},
+		{0.99, false, false, true},
+		{1, false, true, true},
+		{5, false, true, true},
+	}
+
+	for _, test := range tests {
+		shedder := newTestShedder(t, test.pressure)
+		shed := []bool{
+			shedder.shouldShed(PriorityCritical),
+			shedder.shouldShed(PriorityNormal),
+			shedder.shouldShed(PriorityBulk),
+		}
+
+		if shed[0] != test.critical || shed[1] != test.normal || shed[2] != test.bulk {
+			t.Errorf("pressure %v: critical, normal and bulk shed %v", test.pressure, shed)
+		}
+	}
+}
+
+func TestPriorityIsRaisedOnlyByTrustedClients(t *testing.T) {
+	shedder := newTestShedder(t, 0)
+
+	tests := []struct {
+		remoteAddr string
+		header     string
+		want       string
+	}{
+		{"10.1.2.3:4000", PriorityCritical, PriorityCritical},
+		{"10.1.2.3:4000", PriorityBulk, PriorityBulk},
+		{"[::1]:4000", PriorityCritical, PriorityCritical},
+		{"192.168.1.1:4000", PriorityCritical, PriorityNormal},
+		{"10.1.2.3:4000", "urgent", PriorityNormal},
+		{"10.1.2.3:4000", "", PriorityNormal},
+		{"garbage", PriorityCritical, PriorityNormal},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.RemoteAddr = test.remoteAddr
+		r.Header.Set("X-Priority", test.header)
+
+		if priority := shedder.priority(r, PriorityNormal); priority != test.want {
+			t.Errorf("%s asking for %q: priority %q, want %q", test.remoteAddr, test.header, priority, test.want)
+		}
+	}
+}
+
+func TestShedderHandlerRejectsShedRequests(t *testing.T) {
+	shedder := newTestShedder(t, 0.9)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	shedder.Handler(PriorityBulk, next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reports", nil))
+
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("bulk request got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	w = httptest.NewRecorder()
+	shedder.Handler(PriorityNormal, next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("normal request got %d", w.Code)
+	}
+}
+
+func TestNewLoadShedder(t *testing.T) {
+	if shedder, err := NewLoadShedder(LoadSheddingConfig{}, discard.NewCounter(), discard.NewGauge()); shedder != nil || err != nil {
+		t.Errorf("disabled shedder: got %v, %v", shedder, err)
+	}
+
+	_, err := NewLoadShedder(LoadSheddingConfig{MaxGoroutines: 10, TrustedNetworks: []string{"10.0.0.0"}},
+		discard.NewCounter(), discard.NewGauge())
+
+	if err == nil {
+		t.Error("invalid trusted network is accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type variant struct {
+	name     string
+	weight   int
+	endpoint endpoint.Endpoint
+}
+
+// Splitter spreads the traffic of a route across its variants according to their weights.
+type Splitter struct {
+	variants []variant
+	total    int
+	sticky   StickyConfig
+
+	mtx  sync.Mutex
+	rand *rand.Rand
+}
+
+// NewSplitter makes a splitter, makeEndpoint builds the endpoint of a variant upstream.
+// Variants are named after their upstream unless named otherwise, names must be unique
+// as the chosen variant is told by its name.
+func NewSplitter(configs []SplitConfig, sticky StickyConfig,
+	makeEndpoint func(upstream string) (endpoint.Endpoint, error)) (*Splitter, error) {
+	splitter := &Splitter{
+		sticky: sticky,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	names := make(map[string]bool, len(configs))
+
+	for _, config := range configs {
+		if config.Weight < 0 {
+			return nil, errors.New(fmt.Sprintf("negative weight of variant %s", config.Upstream))
+		}
+
+		name := config.Name
+
+		if len(name) == 0 {
+			name = config.Upstream
+		}
+
+		if names[name] {
+			return nil, errors.New(fmt.Sprintf("variant %s is split more than once", name))
+		}
+
+		names[name] = true
+		e, err := makeEndpoint(config.Upstream)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "variant %s", name)
+		}
+
+		splitter.variants = append(splitter.variants, variant{name, config.Weight, e})
+		splitter.total += config.Weight
+	}
+
+	if splitter.total == 0 {
+		return nil, errors.New("split needs a variant with a positive weight")
+	}
+
+	return splitter, nil
+}
+
+// Before chooses the variant for the incoming request and keeps it in the context.
+func (splitter *Splitter) Before(ctx context.Context, r *http.Request) context.Context {
+	return WithVariant(ctx, splitter.choose(r).name)
+}
+
+// Endpoint calls the variant chosen by Before.
+func (splitter *Splitter) Endpoint() endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		name := Variant(ctx)
+
+		for _, v := range splitter.variants {
+			if v.name == name {
+				return v.endpoint(ctx, request)
+			}
+		}
+
+		return splitter.variants[0].endpoint(ctx, request)
+	}
+}
+
+func (splitter *Splitter) choose(r *http.Request) variant {
+	var n int
+
+	if key, ok := splitter.stickyKey(r); ok {
+		hash := fnv.New32a()
+		hash.Write([]byte(key))
+		n = int(hash.Sum32() % uint32(splitter.total))
+	} else {
+		splitter.mtx.Lock()
+		n = splitter.rand.Intn(splitter.total)
+		splitter.mtx.Unlock()
+	}
+
+	for _, v := range splitter.variants {
+		if n < v.weight {
+			return v
+		}
+
+		n -= v.weight
+	}
+
+	return splitter.variants[len(splitter.variants)-1]
+}
+
+func (splitter *Splitter) stickyKey(r *http.Request) (string, bool) {
+	if len(splitter.sticky.Header) > 0 {
+		if value := r.Header.Get(splitter.sticky.Header); len(value) > 0 {
+			return value, true
+		}
+	}
+
+	if len(splitter.sticky.Cookie) > 0 {
+		if cookie, err := r.Cookie(splitter.sticky.Cookie); err == nil && len(cookie.Value) > 0 {
+			return cookie.Value, true
+		}
+	}
+
+	return "", false
+}
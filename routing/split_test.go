@@ -0,0 +1,70 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// upstreamEndpoint makes endpoints answering with the name of their upstream.
+func upstreamEndpoint(upstream string) (endpoint.Endpoint, error) {
+	return func(context.Context, interface{}) (interface{}, error) {
+		return upstream, nil
+	}, nil
+}
+
+func TestNewSplitterRejectsDuplicateVariants(t *testing.T) {
+	tests := [][]SplitConfig{
+		{{Upstream: "orders", Weight: 1}, {Upstream: "orders", Weight: 1}},
+		{{Name: "canary", Upstream: "orders", Weight: 1}, {Name: "canary", Upstream: "orders_v2", Weight: 1}},
+		{{Upstream: "orders", Weight: 1}, {Name: "orders", Upstream: "orders_v2", Weight: 1}},
+	}
+
+	for _, configs := range tests {
+		_, err := NewSplitter(configs, StickyConfig{}, upstreamEndpoint)
+
+		if err == nil || !strings.Contains(err.Error(), "more than once") {
+			t.Errorf("%+v: got %v", configs, err)
+		}
+	}
+}
+
+func TestSplitterCallsChosenVariant(t *testing.T) {
+	splitter, err := NewSplitter([]SplitConfig{
+		{Name: "stable", Upstream: "orders", Weight: 1},
+		{Name: "canary", Upstream: "orders", Weight: 0},
+		{Upstream: "orders_v2", Weight: 1},
+	}, StickyConfig{Header: "X-User"}, upstreamEndpoint)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+
+	for i := 0; i < 200; i++ {
+		ctx := splitter.Before(context.Background(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+		response, _ := splitter.Endpoint()(ctx, nil)
+		counts[Variant(ctx)+" "+response.(string)]++
+	}
+
+	if len(counts) != 2 || counts["stable orders"] == 0 || counts["orders_v2 orders_v2"] == 0 {
+		t.Errorf("variants called %v", counts)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("X-User", "alice")
+	first := Variant(splitter.Before(context.Background(), r))
+
+	for i := 0; i < 20; i++ {
+		if variant := Variant(splitter.Before(context.Background(), r)); variant != first {
+			t.Fatalf("sticky client moved from %s to %s", first, variant)
+		}
+	}
+}
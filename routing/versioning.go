@@ -0,0 +1,199 @@
+package routing
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pkg/errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AcceptVersionHeader = "Accept-Version"
+	DeprecationHeader   = "Deprecation"
+	SunsetHeader        = "Sunset"
+)
+
+type version struct {
+	name       string
+	deprecated bool
+	sunset     string
+	endpoint   endpoint.Endpoint
+}
+
+// Versioner dispatches the requests of a route to the endpoint of the requested version.
+type Versioner struct {
+	versions       []version
+	defaultVersion string
+}
+
+// NewVersioner makes a versioner, makeEndpoint builds the endpoint of a version upstream.
+func NewVersioner(configs []VersionConfig, makeEndpoint func(upstream string) (endpoint.Endpoint, error)) (*Versioner, error) {
+	versioner := &Versioner{}
+
+	for _, config := range configs {
+		name := normalizeVersion(config.Name)
+
+		if len(name) == 0 {
+			return nil, errors.New("version name must be set")
+		}
+
+		if _, ok := versioner.find(name); ok {
+			return nil, errors.New(fmt.Sprintf("duplicate version %s", config.Name))
+		}
+
+		v := version{name: name, deprecated: config.Deprecated}
+
+		if len(config.Sunset) > 0 {
+			sunset, err := time.Parse(time.RFC3339, config.Sunset)
+
+			if err != nil {
+				return nil, errors.Wrapf(err, "sunset of version %s", config.Name)
+			}
+
+			v.sunset = sunset.UTC().Format(http.TimeFormat)
+		}
+
+		e, err := makeEndpoint(config.Upstream)
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "version %s", config.Name)
+		}
+
+		v.endpoint = e
+		versioner.versions = append(versioner.versions, v)
+
+		if config.Default {
+			versioner.defaultVersion = name
+		}
+	}
+
+	if len(versioner.defaultVersion) == 0 {
+		versioner.defaultVersion = versioner.versions[len(versioner.versions)-1].name
+	}
+
+	return versioner, nil
+}
+
+// Names returns the versions that can prefix the route path.
+func (versioner *Versioner) Names() []string {
+	names := make([]string, 0, len(versioner.versions))
+
+	for _, v := range versioner.versions {
+		names = append(names, v.name)
+	}
+
+	return names
+}
+
+// Endpoint calls the version chosen by Handler.
+func (versioner *Versioner) Endpoint() endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		v, ok := versioner.find(Version(ctx))
+
+		if !ok {
+			v, _ = versioner.find(versioner.defaultVersion)
+		}
+
+		return v.endpoint(ctx, request)
+	}
+}
+
+// Handler chooses the version of a request, strips the version prefix from its path
+// and marks responses of deprecated versions.
+func (versioner *Versioner) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, path := versioner.requestedVersion(r)
+		v, ok := versioner.find(name)
+
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown API version %s", name), http.StatusBadRequest)
+			return
+		}
+
+		if path != r.URL.Path {
+			u := *r.URL
+			u.Path = path
+			u.RawPath = ""
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = &u
+			r = r2
+		}
+
+		if v.deprecated {
+			w.Header().Set(DeprecationHeader, "true")
+		}
+
+		if len(v.sunset) > 0 {
+			w.Header().Set(SunsetHeader, v.sunset)
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithVersion(r.Context(), v.name)))
+	})
+}
+
+// requestedVersion looks for the version in the path prefix, in the Accept-Version
+// header and in the Accept media type, in that order. It also returns the path
+// without the version prefix.
+func (versioner *Versioner) requestedVersion(r *http.Request) (string, string) {
+	if name, path, ok := splitVersionPrefix(r.URL.Path, versioner.Names()); ok {
+		return name, path
+	}
+
+	if header := r.Header.Get(AcceptVersionHeader); len(header) > 0 {
+		return normalizeVersion(header), r.URL.Path
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if _, params, err := mime.ParseMediaType(accept); err == nil && len(params["version"]) > 0 {
+			return normalizeVersion(params["version"]), r.URL.Path
+		}
+	}
+
+	return versioner.defaultVersion, r.URL.Path
+}
+
+func (versioner *Versioner) find(name string) (version, bool) {
+	for _, v := range versioner.versions {
+		if v.name == name {
+			return v, true
+		}
+	}
+
+	return version{}, false
+}
+
+// splitVersionPrefix strips a leading /<version> segment from the path.
+func splitVersionPrefix(path string, versions []string) (string, string, bool) {
+	for _, name := range versions {
+		prefix := "/" + name
+
+		if path == prefix {
+			return name, "/", true
+		}
+
+		if strings.HasPrefix(path, prefix+"/") {
+			return name, path[len(prefix):], true
+		}
+	}
+
+	return "", path, false
+}
+
+// normalizeVersion makes "2", "v2" and "V2" the same version.
+func normalizeVersion(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if len(name) > 0 && !strings.HasPrefix(name, "v") {
+		name = "v" + name
+	}
+
+	return name
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+var apiKeysBucket = []byte("api_keys")
+
+// BoltKeyStore keeps API keys in an embedded bolt database.
+type BoltKeyStore struct {
+	db *bbolt.DB
+}
+
+func NewBoltKeyStore(path string) (*BoltKeyStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(apiKeysBucket)
+
+		return err
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltKeyStore{db: db}, nil
+}
+
+func (store *BoltKeyStore) Get(id string) (StoredKey, bool, error) {
+	var key StoredKey
+	found := false
+
+	err := store.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(apiKeysBucket).Get([]byte(id))
+
+		if data == nil {
+			return nil
+		}
+
+		found = true
+
+		return json.Unmarshal(data, &key)
+	})
+
+	return key, found, err
+}
+
+func (store *BoltKeyStore) List() ([]StoredKey, error) {
+	keys := make([]StoredKey, 0)
+
+	err := store.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).ForEach(func(_, data []byte) error {
+			var key StoredKey
+
+			if err := json.Unmarshal(data, &key); err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+
+			return nil
+		})
+	})
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, err
+}
+
+func (store *BoltKeyStore) Put(key StoredKey) error {
+	data, err := json.Marshal(key)
+
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(apiKeysBucket).Put([]byte(key.ID), data)
+	})
+}
+
+func (store *BoltKeyStore) Close() error {
+	return store.db.Close()
+}
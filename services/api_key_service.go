@@ -0,0 +1,203 @@
+package services
+
+import (
+	"api-gateway"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyIDBytes     = 9
+	keySecretBytes = 32
+)
+
+// StoredKeyService verifies and manages API keys kept in a KeyStore.
+// Keys are given to clients as "<id>.<secret>", only the hash of the secret is kept.
+type StoredKeyService struct {
+	store KeyStore
+
+	// mtx serializes changes, so that concurrent rotations of a key do not overwrite each other
+	mtx sync.Mutex
+}
+
+func NewStoredKeyService(store KeyStore) *StoredKeyService {
+	return &StoredKeyService{store: store}
+}
+
+func (service *StoredKeyService) VerifyKey(_ context.Context, key string) (api_gateway.Identity, error) {
+	parts := strings.SplitN(key, ".", 2)
+
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: "malformed key"}
+	}
+
+	stored, found, err := service.store.Get(parts[0])
+
+	if err != nil {
+		return api_gateway.Identity{}, errors.Wrap(err, "key store")
+	}
+
+	// Unknown keys are hashed as well, so that they take as long as wrong secrets
+	hash := hashSecret(parts[1])
+
+	if !found || subtle.ConstantTimeCompare([]byte(hash), []byte(stored.Hash)) != 1 {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: "unknown key"}
+	}
+
+	if !stored.Enabled {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: "key is revoked"}
+	}
+
+	identity := api_gateway.Identity{
+		Subject:  stored.Owner,
+		Scopes:   stored.Scopes,
+		ClientID: stored.ID,
+	}
+
+	if stored.ExpiresAt != nil {
+		if !time.Now().Before(*stored.ExpiresAt) {
+			return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: "key is expired"}
+		}
+
+		identity.ExpiresAt = *stored.ExpiresAt
+	}
+
+	return identity, nil
+}
+
+// CreateKey stores a new enabled key for the owner, scopes and expiration time of key.
+func (service *StoredKeyService) CreateKey(_ context.Context, key api_gateway.APIKey) (api_gateway.APIKey, string, error) {
+	if len(key.Owner) == 0 {
+		return api_gateway.APIKey{}, "", api_gateway.InvalidAPIKeyError{Reason: "owner is missing"}
+	}
+
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now()) {
+		return api_gateway.APIKey{}, "", api_gateway.InvalidAPIKeyError{Reason: "expiration time is past"}
+	}
+
+	id, err := randomString(keyIDBytes)
+
+	if err != nil {
+		return api_gateway.APIKey{}, "", err
+	}
+
+	key.ID = id
+	key.Enabled = true
+	key.CreatedAt = time.Now().UTC()
+
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	return service.issue(key)
+}
+
+func (service *StoredKeyService) ListKeys(_ context.Context) ([]api_gateway.APIKey, error) {
+	stored, err := service.store.List()
+
+	if err != nil {
+		return nil, errors.Wrap(err, "key store")
+	}
+
+	keys := make([]api_gateway.APIKey, 0, len(stored))
+
+	for _, key := range stored {
+		keys = append(keys, key.APIKey)
+	}
+
+	return keys, nil
+}
+
+// RotateKey replaces the secret of a key, the previous key stops working at once.
+func (service *StoredKeyService) RotateKey(_ context.Context, id string) (api_gateway.APIKey, string, error) {
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	stored, err := service.get(id)
+
+	if err != nil {
+		return api_gateway.APIKey{}, "", err
+	}
+
+	if !stored.Enabled {
+		return api_gateway.APIKey{}, "", api_gateway.InvalidAPIKeyError{Reason: "key is revoked"}
+	}
+
+	return service.issue(stored.APIKey)
+}
+
+// RevokeKey disables a key, it stays listed so that its owner can still be looked up.
+func (service *StoredKeyService) RevokeKey(_ context.Context, id string) (api_gateway.APIKey, error) {
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	stored, err := service.get(id)
+
+	if err != nil {
+		return api_gateway.APIKey{}, err
+	}
+
+	stored.Enabled = false
+
+	if err := service.store.Put(stored); err != nil {
+		return api_gateway.APIKey{}, errors.Wrap(err, "key store")
+	}
+
+	return stored.APIKey, nil
+}
+
+func (service *StoredKeyService) Close() error {
+	return service.store.Close()
+}
+
+func (service *StoredKeyService) get(id string) (StoredKey, error) {
+	stored, found, err := service.store.Get(id)
+
+	if err != nil {
+		return StoredKey{}, errors.Wrap(err, "key store")
+	}
+
+	if !found {
+		return StoredKey{}, api_gateway.APIKeyNotFoundError{ID: id}
+	}
+
+	return stored, nil
+}
+
+// issue stores key with a new secret and returns the key given to the client.
+func (service *StoredKeyService) issue(key api_gateway.APIKey) (api_gateway.APIKey, string, error) {
+	secret, err := randomString(keySecretBytes)
+
+	if err != nil {
+		return api_gateway.APIKey{}, "", err
+	}
+
+	if err := service.store.Put(StoredKey{APIKey: key, Hash: hashSecret(secret)}); err != nil {
+		return api_gateway.APIKey{}, "", errors.Wrap(err, "key store")
+	}
+
+	return key, key.ID + "." + secret, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
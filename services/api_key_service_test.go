@@ -0,0 +1,150 @@
+package services
+
+import (
+	"api-gateway"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestKeyService(t *testing.T) *StoredKeyService {
+	store, err := NewFileKeyStore(filepath.Join(tempDir(t), "keys.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewStoredKeyService(store)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "api-keys")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	return dir
+}
+
+func createKey(t *testing.T, service *StoredKeyService, key api_gateway.APIKey) (api_gateway.APIKey, string) {
+	created, secret, err := service.CreateKey(context.Background(), key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return created, secret
+}
+
+func assertInvalidKey(t *testing.T, service *StoredKeyService, key, reason string) {
+	t.Helper()
+
+	_, err := service.VerifyKey(context.Background(), key)
+
+	if invalid, ok := err.(api_gateway.InvalidTokenError); !ok || invalid.Reason != reason {
+		t.Errorf("key %q: got %v, want %s", key, err, reason)
+	}
+}
+
+func TestVerifyKey(t *testing.T) {
+	service := newTestKeyService(t)
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	created, key := createKey(t, service, api_gateway.APIKey{Owner: "billing", Scopes: []string{"read"}, ExpiresAt: &expiresAt})
+
+	identity, err := service.VerifyKey(context.Background(), key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Subject != "billing" || identity.ClientID != created.ID || !identity.ExpiresAt.Equal(expiresAt) ||
+		len(identity.Scopes) != 1 || identity.Scopes[0] != "read" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	id := strings.SplitN(key, ".", 2)[0]
+
+	for _, malformed := range []string{"", "nodot", ".secret", id + "."} {
+		assertInvalidKey(t, service, malformed, "malformed key")
+	}
+
+	assertInvalidKey(t, service, "unknown."+strings.SplitN(key, ".", 2)[1], "unknown key")
+	assertInvalidKey(t, service, id+".wrong", "unknown key")
+}
+
+func TestVerifyKeyRejectsRevokedKeys(t *testing.T) {
+	service := newTestKeyService(t)
+	created, key := createKey(t, service, api_gateway.APIKey{Owner: "billing"})
+
+	if _, err := service.RevokeKey(context.Background(), created.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	assertInvalidKey(t, service, key, "key is revoked")
+
+	if _, _, err := service.RotateKey(context.Background(), created.ID); err == nil {
+		t.Error("revoked key is rotated")
+	}
+}
+
+func TestVerifyKeyRejectsExpiredKeys(t *testing.T) {
+	service := newTestKeyService(t)
+	created, key := createKey(t, service, api_gateway.APIKey{Owner: "billing"})
+
+	// Keys can not be created expired, so the stored key expires afterwards
+	stored, _, _ := service.store.Get(created.ID)
+	past := time.Now().Add(-time.Minute)
+	stored.ExpiresAt = &past
+
+	if err := service.store.Put(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	assertInvalidKey(t, service, key, "key is expired")
+}
+
+func TestRotateKeyInvalidatesPreviousSecret(t *testing.T) {
+	service := newTestKeyService(t)
+	created, previous := createKey(t, service, api_gateway.APIKey{Owner: "billing"})
+
+	rotated, key, err := service.RotateKey(context.Background(), created.ID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated.ID != created.ID || key == previous || !strings.HasPrefix(key, created.ID+".") {
+		t.Errorf("rotated key %+v, %q", rotated, key)
+	}
+
+	assertInvalidKey(t, service, previous, "unknown key")
+
+	if _, err := service.VerifyKey(context.Background(), key); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+}
+
+func TestKeyOperationsOnMissingKeys(t *testing.T) {
+	service := newTestKeyService(t)
+
+	if _, _, err := service.RotateKey(context.Background(), "missing"); err != (api_gateway.APIKeyNotFoundError{ID: "missing"}) {
+		t.Errorf("rotate: got %v", err)
+	}
+
+	if _, err := service.RevokeKey(context.Background(), "missing"); err != (api_gateway.APIKeyNotFoundError{ID: "missing"}) {
+		t.Errorf("revoke: got %v", err)
+	}
+
+	if _, _, err := service.CreateKey(context.Background(), api_gateway.APIKey{}); err == nil {
+		t.Error("key without owner is created")
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"api-gateway"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// StoredKey is an API key as kept by a KeyStore, with the hash of the key instead of the key.
+type StoredKey struct {
+	api_gateway.APIKey
+	Hash string `json:"hash"`
+}
+
+// KeyStore keeps API keys by their id.
+type KeyStore interface {
+	Get(id string) (StoredKey, bool, error)
+	List() ([]StoredKey, error)
+	Put(key StoredKey) error
+	Close() error
+}
+
+// NewKeyStore opens the key store selected by config.
+func NewKeyStore(config api_gateway.APIKeysConfig) (KeyStore, error) {
+	if len(config.Path) == 0 {
+		return nil, errors.New("key store path is missing")
+	}
+
+	switch config.Store {
+	case "file":
+		return NewFileKeyStore(config.Path)
+	case "bolt":
+		return NewBoltKeyStore(config.Path)
+	default:
+		return nil, errors.Errorf("unknown key store %q", config.Store)
+	}
+}
+
+// FileKeyStore keeps API keys in memory and writes all of them to a JSON file on every change.
+type FileKeyStore struct {
+	path string
+
+	mtx  sync.RWMutex
+	keys map[string]StoredKey
+}
+
+// NewFileKeyStore loads the keys of the file, a missing file is created on the first change.
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	store := &FileKeyStore{
+		path: path,
+		keys: make(map[string]StoredKey),
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []StoredKey
+
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+
+	for _, key := range keys {
+		store.keys[key.ID] = key
+	}
+
+	return store, nil
+}
+
+func (store *FileKeyStore) Get(id string) (StoredKey, bool, error) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	key, ok := store.keys[id]
+
+	return key, ok, nil
+}
+
+func (store *FileKeyStore) List() ([]StoredKey, error) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	return store.sorted(), nil
+}
+
+func (store *FileKeyStore) sorted() []StoredKey {
+	keys := make([]StoredKey, 0, len(store.keys))
+
+	for _, key := range store.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
+
+// Put saves key and writes the file, the file is replaced at once so that it is never half written.
+func (store *FileKeyStore) Put(key StoredKey) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	previous, existed := store.keys[key.ID]
+	store.keys[key.ID] = key
+
+	if err := store.write(); err != nil {
+		if existed {
+			store.keys[key.ID] = previous
+		} else {
+			delete(store.keys, key.ID)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (store *FileKeyStore) write() error {
+	data, err := json.MarshalIndent(store.sorted(), "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), store.path)
+}
+
+func (store *FileKeyStore) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"api-gateway"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeyStoresRoundTrip(t *testing.T) {
+	stores := map[string]func(path string) (KeyStore, error){
+		"file": func(path string) (KeyStore, error) {
+			return NewFileKeyStore(path)
+		},
+		"bolt": func(path string) (KeyStore, error) {
+			return NewBoltKeyStore(path)
+		},
+	}
+
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	expiresAt := createdAt.Add(24 * time.Hour)
+	keys := []StoredKey{
+		{api_gateway.APIKey{ID: "b", Owner: "billing", Scopes: []string{"read", "write"}, ExpiresAt: &expiresAt,
+			Enabled: true, CreatedAt: createdAt.Add(time.Second)}, "hash-b"},
+		{api_gateway.APIKey{ID: "a", Owner: "reports", Enabled: false, CreatedAt: createdAt}, "hash-a"},
+	}
+
+	for name, open := range stores {
+		path := filepath.Join(tempDir(t), "keys")
+		store, err := open(path)
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		for _, key := range keys {
+			if err := store.Put(key); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		updated := keys[0]
+		updated.Hash = "hash-b2"
+
+		if err := store.Put(updated); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		store.Close()
+
+		// Keys must survive reopening the store
+		if store, err = open(path); err != nil {
+			t.Fatalf("%s: reopen: %v", name, err)
+		}
+
+		key, found, err := store.Get("b")
+
+		if err != nil || !found || !reflect.DeepEqual(key, updated) {
+			t.Errorf("%s: got %+v, %v, %v", name, key, found, err)
+		}
+
+		if _, found, err := store.Get("missing"); found || err != nil {
+			t.Errorf("%s: missing key found %v, %v", name, found, err)
+		}
+
+		listed, err := store.List()
+		want := []StoredKey{keys[1], updated}
+
+		if err != nil || !reflect.DeepEqual(listed, want) {
+			t.Errorf("%s: listed %+v, %v", name, listed, err)
+		}
+
+		store.Close()
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"api-gateway"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// Cache events counted by CachingTokenService.
+const (
+	CacheHit          = "hit"
+	CacheMiss         = "miss"
+	CacheEviction     = "eviction"
+	CacheInvalidation = "invalidation"
+)
+
+type verification struct {
+	key      [sha256.Size]byte
+	identity api_gateway.Identity
+	err      error
+	expires  time.Time
+}
+
+// CachingTokenService keeps the results of VerifyToken by the hash of the token for ttl,
+// rejected tokens for negativeTTL. Valid tokens are not kept past their expiration time.
+// At most maxEntries results are kept, the least recently used ones are evicted first.
+// Tokens revoked through the service are dropped from the cache.
+type CachingTokenService struct {
+	next        api_gateway.TokenService
+	maxEntries  int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	events      metrics.Counter
+
+	mtx      sync.Mutex
+	entries  map[[sha256.Size]byte]*list.Element
+	lru      *list.List
+	inflight map[[sha256.Size]byte]*flight
+}
+
+// flight counts the verifications of a token in progress, generation changes when the
+// token is invalidated meanwhile so that their results are not cached.
+type flight struct {
+	calls      int
+	generation uint64
+}
+
+func NewCachingTokenService(next api_gateway.TokenService, maxEntries int, ttl, negativeTTL time.Duration,
+	events metrics.Counter) *CachingTokenService {
+	return &CachingTokenService{
+		next:        next,
+		maxEntries:  maxEntries,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		events:      events,
+		entries:     make(map[[sha256.Size]byte]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[[sha256.Size]byte]*flight),
+	}
+}
+
+func (service *CachingTokenService) IssueToken(ctx context.Context, login, password string) (string, error) {
+	return service.next.IssueToken(ctx, login, password)
+}
+
+// VerifyToken returns the cached result for the token or verifies it with next,
+// only valid tokens and the ones next rejects as invalid are cached.
+func (service *CachingTokenService) VerifyToken(ctx context.Context, token string) (api_gateway.Identity, error) {
+	key := sha256.Sum256([]byte(token))
+
+	if entry, ok := service.get(key); ok {
+		service.events.With("event", CacheHit).Add(1)
+		return entry.identity, entry.err
+	}
+
+	service.events.With("event", CacheMiss).Add(1)
+	generation := service.start(key)
+	identity, err := service.next.VerifyToken(ctx, token)
+	now := time.Now()
+	entry := &verification{key: key, identity: identity, err: err}
+
+	switch {
+	case err == nil:
+		entry.expires = now.Add(service.ttl)
+
+		if !identity.ExpiresAt.IsZero() && identity.ExpiresAt.Before(entry.expires) {
+			entry.expires = identity.ExpiresAt
+		}
+	case errors.As(err, new(api_gateway.InvalidTokenError)) && service.negativeTTL > 0:
+		entry.expires = now.Add(service.negativeTTL)
+	default:
+		service.finish(entry, generation, false)
+		return identity, err
+	}
+
+	service.finish(entry, generation, entry.expires.After(now))
+
+	return identity, err
+}
+
+// start records a verification of the token in progress and returns its generation.
+func (service *CachingTokenService) start(key [sha256.Size]byte) uint64 {
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	f, ok := service.inflight[key]
+
+	if !ok {
+		f = &flight{}
+		service.inflight[key] = f
+	}
+
+	f.calls++
+
+	return f.generation
+}
+
+// finish ends a verification started at generation and caches its result if asked to,
+// unless the token was invalidated while it was verified.
+func (service *CachingTokenService) finish(entry *verification, generation uint64, cache bool) {
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	f := service.inflight[entry.key]
+	f.calls--
+
+	if f.calls == 0 {
+		delete(service.inflight, entry.key)
+	}
+
+	if cache && f.generation == generation {
+		service.put(entry)
+	}
+}
+
+func (service *CachingTokenService) get(key [sha256.Size]byte) (*verification, bool) {
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	element, ok := service.entries[key]
+
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*verification)
+
+	if time.Now().After(entry.expires) {
+		service.lru.Remove(element)
+		delete(service.entries, key)
+		return nil, false
+	}
+
+	service.lru.MoveToFront(element)
+
+	return entry, true
+}
+
+// put caches entry, the caller holds mtx.
+func (service *CachingTokenService) put(entry *verification) {
+	if element, ok := service.entries[entry.key]; ok {
+		element.Value = entry
+		service.lru.MoveToFront(element)
+		return
+	}
+
+	service.entries[entry.key] = service.lru.PushFront(entry)
+
+	for service.lru.Len() > service.maxEntries {
+		oldest := service.lru.Back()
+		service.lru.Remove(oldest)
+		delete(service.entries, oldest.Value.(*verification).key)
+		service.events.With("event", CacheEviction).Add(1)
+	}
+}
+
+// Invalidate drops the cached result for token, verifications of the token in progress are not cached.
+func (service *CachingTokenService) Invalidate(token string) {
+	key := sha256.Sum256([]byte(token))
+
+	service.mtx.Lock()
+	defer service.mtx.Unlock()
+
+	if f, ok := service.inflight[key]; ok {
+		f.generation++
+	}
+
+	if element, ok := service.entries[key]; ok {
+		service.lru.Remove(element)
+		delete(service.entries, key)
+		service.events.With("event", CacheInvalidation).Add(1)
+	}
+}
+
+// RevokeToken revokes the token with next and drops its cached result,
+// even if revoking fails the token may have been revoked.
+func (service *CachingTokenService) RevokeToken(ctx context.Context, token string) error {
+	err := service.next.RevokeToken(ctx, token)
+	service.Invalidate(token)
+
+	return err
+}
+
+func (service *CachingTokenService) HealthCheck() bool {
+	return service.next.HealthCheck()
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"api-gateway"
+	"context"
+	"github.com/go-kit/kit/metrics/discard"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowTokenService answers VerifyToken once release is closed and rejects revoked tokens.
+type slowTokenService struct {
+	started chan struct{}
+	release chan struct{}
+
+	mtx     sync.Mutex
+	revoked map[string]bool
+	calls   int
+}
+
+func newSlowTokenService() *slowTokenService {
+	return &slowTokenService{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+		revoked: make(map[string]bool),
+	}
+}
+
+func (s *slowTokenService) IssueToken(context.Context, string, string) (string, error) {
+	return "", nil
+}
+
+func (s *slowTokenService) VerifyToken(_ context.Context, token string) (api_gateway.Identity, error) {
+	s.mtx.Lock()
+	revoked := s.revoked[token]
+	s.calls++
+	s.mtx.Unlock()
+
+	// The answer reflects the state when the call reached the token service
+	s.started <- struct{}{}
+	<-s.release
+
+	if revoked {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: "revoked"}
+	}
+
+	return api_gateway.Identity{Subject: "alice"}, nil
+}
+
+func (s *slowTokenService) RevokeToken(_ context.Context, token string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.revoked[token] = true
+
+	return nil
+}
+
+func (s *slowTokenService) HealthCheck() bool {
+	return true
+}
+
+func TestRevokeDuringVerificationIsNotCached(t *testing.T) {
+	next := newSlowTokenService()
+	cache := NewCachingTokenService(next, 10, time.Minute, 0, discard.NewCounter())
+	done := make(chan error)
+
+	go func() {
+		_, err := cache.VerifyToken(context.Background(), "token")
+		done <- err
+	}()
+
+	<-next.started
+
+	if err := cache.RevokeToken(context.Background(), "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	close(next.release)
+
+	if err := <-done; err != nil {
+		t.Fatalf("verification started before revocation: %v", err)
+	}
+
+	if _, err := cache.VerifyToken(context.Background(), "token"); err == nil {
+		t.Fatal("revoked token is still valid")
+	}
+
+	if next.calls != 2 {
+		t.Errorf("token service verified the token %d times, want 2", next.calls)
+	}
+}
+
+func TestVerificationIsCached(t *testing.T) {
+	next := newSlowTokenService()
+	close(next.release)
+	cache := NewCachingTokenService(next, 10, time.Minute, 0, discard.NewCounter())
+
+	for i := 0; i < 3; i++ {
+		identity, err := cache.VerifyToken(context.Background(), "token")
+
+		if err != nil || identity.Subject != "alice" {
+			t.Fatalf("got %v, %v", identity, err)
+		}
+	}
+
+	if next.calls != 1 {
+		t.Errorf("token service verified the token %d times, want 1", next.calls)
+	}
+
+	cache.Invalidate("token")
+
+	if _, err := cache.VerifyToken(context.Background(), "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	if next.calls != 2 {
+		t.Errorf("invalidated token was served from the cache")
+	}
+
+	if len(cache.inflight) != 0 {
+		t.Errorf("%d verifications left in flight", len(cache.inflight))
+	}
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minKeyReload limits how often unknown key ids make the key set reload.
+const minKeyReload = 10 * time.Second
+
+// jsonWebKey is a public key of a JWKS document as defined by RFC 7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// signingKey is a public key and the algorithm it is restricted to, Alg is empty when
+// the key may be used with any algorithm of its kind.
+type signingKey struct {
+	Key crypto.PublicKey
+	Alg string
+}
+
+// KeySet holds the public keys tokens are signed with, loaded from a JWKS file or URL.
+type KeySet struct {
+	file     string
+	url      string
+	client   *http.Client
+	interval time.Duration
+	logger   log.Logger
+	stop     chan struct{}
+
+	mtx      sync.RWMutex
+	keys     map[string]signingKey
+	loadedAt time.Time
+
+	// reloadedAt is when unknown key ids last made the set reload, whether it succeeded or not,
+	// reloading is closed when that reload is done.
+	reloadedAt time.Time
+	reloading  chan struct{}
+}
+
+// NewKeySet loads the keys and reloads them every interval. Keys of a file must load,
+// a URL that can not be fetched yet is fetched again on the next reload.
+func NewKeySet(file, url string, interval time.Duration, logger log.Logger) (*KeySet, error) {
+	if len(file) == 0 && len(url) == 0 {
+		return nil, errors.New("either JWKS file or URL must be set")
+	}
+
+	keySet := &KeySet{
+		file:     file,
+		url:      url,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: interval,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		keys:     make(map[string]signingKey),
+	}
+
+	if err := keySet.load(context.Background()); err != nil {
+		if len(file) > 0 {
+			return nil, err
+		}
+
+		logger.Log("msg", "JWKS can not be loaded yet", "err", err)
+	}
+
+	if interval > 0 {
+		go keySet.run()
+	}
+
+	return keySet, nil
+}
+
+func (keySet *KeySet) run() {
+	ticker := time.NewTicker(keySet.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := keySet.load(context.Background()); err != nil {
+				keySet.logger.Log("msg", "JWKS reload failed, keeping the loaded keys", "err", err)
+			}
+		case <-keySet.stop:
+			return
+		}
+	}
+}
+
+func (keySet *KeySet) load(ctx context.Context) error {
+	document, err := keySet.read(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := json.Unmarshal(document, &jwks); err != nil {
+		return errors.Wrap(err, "parse JWKS")
+	}
+
+	keys := make(map[string]signingKey, len(jwks.Keys))
+
+	for _, jwk := range jwks.Keys {
+		// use is optional, keys without it may sign tokens as well
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			keySet.logger.Log("msg", "JWKS key skipped", "kid", jwk.Kid, "use", jwk.Use)
+			continue
+		}
+
+		key, err := jwk.publicKey()
+
+		if err != nil {
+			keySet.logger.Log("msg", "JWKS key skipped", "kid", jwk.Kid, "err", err)
+			continue
+		}
+
+		keys[jwk.Kid] = signingKey{Key: key, Alg: jwk.Alg}
+	}
+
+	keySet.mtx.Lock()
+	keySet.keys = keys
+	keySet.loadedAt = time.Now()
+	keySet.mtx.Unlock()
+
+	return nil
+}
+
+func (keySet *KeySet) read(ctx context.Context) ([]byte, error) {
+	if len(keySet.file) > 0 {
+		return ioutil.ReadFile(keySet.file)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keySet.url, nil)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch JWKS")
+	}
+
+	resp, err := keySet.client.Do(req)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch JWKS")
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("fetch JWKS: status %d", resp.StatusCode))
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Key finds the key with the given id, a token without one may use the only key of the set.
+// Unknown ids make the set reload, keys may have been rotated since it was loaded.
+// Reloads happen at most every minKeyReload and are shared by concurrent callers,
+// which stop waiting for them when ctx is done.
+func (keySet *KeySet) Key(ctx context.Context, kid string) (signingKey, bool) {
+	if key, ok := keySet.find(kid); ok {
+		return key, true
+	}
+
+	if done := keySet.reload(); done != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return signingKey{}, false
+		}
+	}
+
+	return keySet.find(kid)
+}
+
+// reload starts a reload unless one is running or one was started within minKeyReload,
+// the returned channel is closed when the running reload is done and nil if there is none.
+func (keySet *KeySet) reload() chan struct{} {
+	keySet.mtx.Lock()
+	defer keySet.mtx.Unlock()
+
+	if keySet.reloading != nil || time.Since(keySet.reloadedAt) < minKeyReload {
+		return keySet.reloading
+	}
+
+	keySet.reloadedAt = time.Now()
+	done := make(chan struct{})
+	keySet.reloading = done
+
+	// The reload is not bound to the request that started it, others may be waiting for it
+	go func() {
+		if err := keySet.load(context.Background()); err != nil {
+			keySet.logger.Log("msg", "JWKS reload failed", "err", err)
+		}
+
+		keySet.mtx.Lock()
+		keySet.reloading = nil
+		keySet.mtx.Unlock()
+		close(done)
+	}()
+
+	return done
+}
+
+func (keySet *KeySet) find(kid string) (signingKey, bool) {
+	keySet.mtx.RLock()
+	defer keySet.mtx.RUnlock()
+
+	if len(kid) == 0 && len(keySet.keys) == 1 {
+		for _, key := range keySet.keys {
+			return key, true
+		}
+	}
+
+	key, ok := keySet.keys[kid]
+
+	return key, ok
+}
+
+// Loaded reports whether the keys were loaded at least once.
+func (keySet *KeySet) Loaded() bool {
+	keySet.mtx.RLock()
+	defer keySet.mtx.RUnlock()
+
+	return !keySet.loadedAt.IsZero()
+}
+
+// Stop stops reloading the keys.
+func (keySet *KeySet) Stop() {
+	close(keySet.stop)
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent is too large")
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New(fmt.Sprintf("unsupported curve %q", jwk.Crv))
+		}
+
+		x, err := decodeBigInt(jwk.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.New(fmt.Sprintf("unsupported curve %q", jwk.Crv))
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported key type %q", jwk.Kty))
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"math/big"
+	"strings"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// jwtClaims are the registered claims of RFC 7519 and the OAuth scopes.
+type jwtClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	ExpiresAt       *float64 `json:"exp"`
+	NotBefore       *float64 `json:"nbf"`
+	IssuedAt        *float64 `json:"iat"`
+	Scope           string   `json:"scope"`
+	Scopes          []string `json:"scp"`
+	ClientID        string   `json:"client_id"`
+	AuthorizedParty string   `json:"azp"`
+}
+
+// audience is either a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = list
+
+	return nil
+}
+
+// scopes reads the space separated scope claim or else the scp list.
+func (claims jwtClaims) scopes() []string {
+	if len(claims.Scope) > 0 {
+		return strings.Fields(claims.Scope)
+	}
+
+	return claims.Scopes
+}
+
+// parseJWT checks the signature of a compact serialized JWT with the key found by
+// its key id and returns its claims. Only asymmetric algorithms are accepted.
+func parseJWT(token string, key func(kid string) (signingKey, bool)) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, errors.Wrap(err, "malformed header")
+	}
+
+	publicKey, ok := key(header.Kid)
+
+	if !ok {
+		return claims, errors.New(fmt.Sprintf("unknown key %q", header.Kid))
+	}
+
+	if len(publicKey.Alg) > 0 && publicKey.Alg != header.Alg {
+		return claims, errors.New(fmt.Sprintf("key %q is not for algorithm %q", header.Kid, header.Alg))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return claims, errors.New("malformed signature")
+	}
+
+	if err := verifySignature(header.Alg, publicKey.Key, parts[0]+"."+parts[1], signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, errors.Wrap(err, "malformed claims")
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+var signingHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// ecdsaKeySizes are the sizes of the curves each ECDSA algorithm is defined for.
+var ecdsaKeySizes = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+// verifySignature checks signature with the algorithm of the token, the key must be of the kind it uses.
+func verifySignature(alg string, publicKey crypto.PublicKey, signed string, signature []byte) error {
+	invalid := errors.New("invalid signature")
+
+	if alg == "EdDSA" {
+		key, ok := publicKey.(ed25519.PublicKey)
+
+		if !ok || !ed25519.Verify(key, []byte(signed), signature) {
+			return invalid
+		}
+
+		return nil
+	}
+
+	hash, ok := signingHashes[alg]
+
+	if !ok {
+		return errors.New(fmt.Sprintf("unsupported algorithm %q", alg))
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		var err error
+
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case "PS":
+			err = rsa.VerifyPSS(key, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			return invalid
+		}
+
+		if err != nil {
+			return invalid
+		}
+
+		return nil
+	case *ecdsa.PublicKey:
+		bits := key.Curve.Params().BitSize
+		size := (bits + 7) / 8
+
+		if ecdsaKeySizes[alg] != bits || len(signature) != 2*size {
+			return invalid
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+
+		if !ecdsa.Verify(key, digest, r, s) {
+			return invalid
+		}
+
+		return nil
+	default:
+		return invalid
+	}
+}
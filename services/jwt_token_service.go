@@ -0,0 +1,149 @@
+package services
+
+import (
+	"api-gateway"
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"math"
+	"time"
+)
+
+// JWTTokenService verifies tokens as signed JWTs without calling the token service,
+// other methods and revocation checks go to next.
+type JWTTokenService struct {
+	next            api_gateway.TokenService
+	keys            *KeySet
+	issuer          string
+	audiences       []string
+	clockSkew       time.Duration
+	checkRevocation bool
+}
+
+func NewJWTTokenService(next api_gateway.TokenService, config api_gateway.JWTConfig, logger log.Logger) (*JWTTokenService, error) {
+	keys, err := NewKeySet(config.JWKSFile, config.JWKSURL, config.RefreshInterval.Duration, logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &JWTTokenService{
+		next:            next,
+		keys:            keys,
+		issuer:          config.Issuer,
+		audiences:       config.Audiences,
+		clockSkew:       config.ClockSkew.Duration,
+		checkRevocation: config.CheckRevocation,
+	}, nil
+}
+
+func (service *JWTTokenService) IssueToken(ctx context.Context, login, password string) (string, error) {
+	return service.next.IssueToken(ctx, login, password)
+}
+
+// VerifyToken checks the signature and the claims of the token,
+// revoked tokens are found by the token service if the check is configured.
+func (service *JWTTokenService) VerifyToken(ctx context.Context, token string) (api_gateway.Identity, error) {
+	// Tokens can not be told valid or not before the keys are known
+	if !service.keys.Loaded() {
+		return api_gateway.Identity{}, errors.New("token signing keys are not loaded yet")
+	}
+
+	claims, err := parseJWT(token, func(kid string) (signingKey, bool) {
+		return service.keys.Key(ctx, kid)
+	})
+
+	if err != nil {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: err.Error()}
+	}
+
+	if reason := service.invalidClaims(claims, time.Now()); len(reason) > 0 {
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: reason}
+	}
+
+	if service.checkRevocation {
+		if _, err := service.next.VerifyToken(ctx, token); err != nil {
+			return api_gateway.Identity{}, err
+		}
+	}
+
+	identity := api_gateway.Identity{
+		Subject:   claims.Subject,
+		Scopes:    claims.scopes(),
+		ClientID:  claims.ClientID,
+		Issuer:    claims.Issuer,
+		Audiences: claims.Audience,
+		ExpiresAt: numericDate(*claims.ExpiresAt),
+	}
+
+	// OpenID Connect names the client in azp instead
+	if len(identity.ClientID) == 0 {
+		identity.ClientID = claims.AuthorizedParty
+	}
+
+	if claims.IssuedAt != nil {
+		identity.IssuedAt = numericDate(*claims.IssuedAt)
+	}
+
+	return identity, nil
+}
+
+// invalidClaims tells why the claims are not valid at now, time claims allow for the clock skew.
+func (service *JWTTokenService) invalidClaims(claims jwtClaims, now time.Time) string {
+	if claims.ExpiresAt == nil {
+		return "token has no expiration time"
+	}
+
+	if now.Add(-service.clockSkew).After(numericDate(*claims.ExpiresAt)) {
+		return "token is expired"
+	}
+
+	if claims.NotBefore != nil && now.Add(service.clockSkew).Before(numericDate(*claims.NotBefore)) {
+		return "token is not valid yet"
+	}
+
+	if claims.IssuedAt != nil && now.Add(service.clockSkew).Before(numericDate(*claims.IssuedAt)) {
+		return "token is issued in the future"
+	}
+
+	if len(service.issuer) > 0 && claims.Issuer != service.issuer {
+		return "token has a wrong issuer"
+	}
+
+	if len(service.audiences) > 0 && !intersects(claims.Audience, service.audiences) {
+		return "token is not meant for this audience"
+	}
+
+	return ""
+}
+
+func (service *JWTTokenService) RevokeToken(ctx context.Context, token string) error {
+	return service.next.RevokeToken(ctx, token)
+}
+
+func (service *JWTTokenService) HealthCheck() bool {
+	return service.next.HealthCheck()
+}
+
+// Close stops reloading the keys.
+func (service *JWTTokenService) Close() {
+	service.keys.Stop()
+}
+
+func numericDate(seconds float64) time.Time {
+	whole, fraction := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(fraction*1e9))
+}
+
+func intersects(values, others []string) bool {
+	for _, value := range values {
+		for _, other := range others {
+			if value == other {
+				return true
+			}
+		}
+	}
+
+	return false
+}
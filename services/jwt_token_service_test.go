@@ -0,0 +1,406 @@
+package services
+
+import (
+	"api-gateway"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ec      *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	edPub   ed25519.PublicKey
+	rsaOnly *rsa.PrivateKey
+}
+
+var (
+	keysOnce sync.Once
+	keys     testKeys
+)
+
+func generateKeys(t *testing.T) testKeys {
+	keysOnce.Do(func() {
+		var err error
+
+		if keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+
+		if keys.rsaOnly, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+
+		if keys.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+
+		if keys.edPub, keys.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	return keys
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func encodeInt(i *big.Int, size int) string {
+	b := i.Bytes()
+
+	for len(b) < size {
+		b = append([]byte{0}, b...)
+	}
+
+	return encode(b)
+}
+
+// jwksDocument publishes the test keys: "rsa" for any RSA algorithm, "rs256" only for RS256,
+// "ec" for ES256, "ed" for EdDSA and "enc" for encryption only.
+func jwksDocument(k testKeys) []byte {
+	rsaKey := func(kid, alg, use string, key *rsa.PublicKey) map[string]string {
+		return map[string]string{"kty": "RSA", "kid": kid, "alg": alg, "use": use,
+			"n": encode(key.N.Bytes()), "e": encode(big.NewInt(int64(key.E)).Bytes())}
+	}
+
+	document, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		rsaKey("rsa", "", "sig", &k.rsa.PublicKey),
+		rsaKey("rs256", "RS256", "sig", &k.rsaOnly.PublicKey),
+		rsaKey("enc", "", "enc", &k.rsa.PublicKey),
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "use": "sig",
+			"x": encodeInt(k.ec.X, 32), "y": encodeInt(k.ec.Y, 32)},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": encode(k.edPub)},
+	}})
+
+	return document
+}
+
+// sign makes a JWT of claims signed by key with alg, the key id is kid.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := encode(header) + "." + encode(payload)
+
+	var (
+		signature []byte
+		err       error
+	)
+
+	switch alg {
+	case "EdDSA":
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	default:
+		hash := signingHashes[alg]
+		hasher := hash.New()
+		hasher.Write([]byte(signed))
+		digest := hasher.Sum(nil)
+
+		switch alg[:2] {
+		case "RS":
+			signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), hash, digest)
+		case "PS":
+			signature, err = rsa.SignPSS(rand.Reader, key.(*rsa.PrivateKey), hash, digest,
+				&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		case "ES":
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest)
+			size := (key.(*ecdsa.PrivateKey).Curve.Params().BitSize + 7) / 8
+			signature = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + encode(signature)
+}
+
+func newTestJWTService(t *testing.T, document []byte) *JWTTokenService {
+	file := filepath.Join(t.TempDir(), "jwks.json")
+
+	if err := ioutil.WriteFile(file, document, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keySet, err := NewKeySet(file, "", 0, log.NewNopLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &JWTTokenService{
+		keys:      keySet,
+		issuer:    "token_service",
+		audiences: []string{"api-gateway"},
+		clockSkew: 30 * time.Second,
+	}
+}
+
+func validClaims() map[string]interface{} {
+	now := time.Now()
+
+	return map[string]interface{}{
+		"sub":       "alice",
+		"iss":       "token_service",
+		"aud":       "api-gateway",
+		"exp":       now.Add(time.Hour).Unix(),
+		"iat":       now.Unix(),
+		"scope":     "orders:read orders:write",
+		"client_id": "web",
+	}
+}
+
+func with(claims map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	changed := make(map[string]interface{}, len(claims))
+
+	for k, v := range claims {
+		changed[k] = v
+	}
+
+	if value == nil {
+		delete(changed, name)
+	} else {
+		changed[name] = value
+	}
+
+	return changed
+}
+
+func assertInvalid(t *testing.T, service *JWTTokenService, name, token string) {
+	t.Helper()
+
+	_, err := service.VerifyToken(context.Background(), token)
+
+	if !errors.As(err, new(api_gateway.InvalidTokenError)) {
+		t.Errorf("%s: got %v, want an invalid token", name, err)
+	}
+}
+
+func TestJWTAcceptsSupportedAlgorithms(t *testing.T) {
+	k := generateKeys(t)
+	service := newTestJWTService(t, jwksDocument(k))
+
+	tokens := map[string]string{
+		"RS256": sign(t, "RS256", "rsa", k.rsa, validClaims()),
+		"RS512": sign(t, "RS512", "rsa", k.rsa, validClaims()),
+		"PS256": sign(t, "PS256", "rsa", k.rsa, validClaims()),
+		"ES256": sign(t, "ES256", "ec", k.ec, validClaims()),
+		"EdDSA": sign(t, "EdDSA", "ed", k.ed, validClaims()),
+	}
+
+	for alg, token := range tokens {
+		identity, err := service.VerifyToken(context.Background(), token)
+
+		if err != nil {
+			t.Errorf("%s: %v", alg, err)
+			continue
+		}
+
+		if identity.Subject != "alice" || identity.ClientID != "web" ||
+			strings.Join(identity.Scopes, " ") != "orders:read orders:write" || identity.ExpiresAt.IsZero() {
+			t.Errorf("%s: identity %+v", alg, identity)
+		}
+	}
+}
+
+func TestJWTRejectsAlgorithmAndKeyMismatches(t *testing.T) {
+	k := generateKeys(t)
+	service := newTestJWTService(t, jwksDocument(k))
+	claims := validClaims()
+
+	// An RSA signature claimed to be ECDSA, and an ECDSA key asked to check RSA
+	parts := strings.Split(sign(t, "RS256", "rsa", k.rsa, claims), ".")
+	assertInvalid(t, service, "RS signature as ES256",
+		encode([]byte(`{"alg":"ES256","kid":"rsa"}`))+"."+parts[1]+"."+parts[2])
+	assertInvalid(t, service, "RS256 with EC key", sign(t, "RS256", "ec", k.rsa, claims))
+	assertInvalid(t, service, "ES256 with RSA key", sign(t, "ES256", "rsa", k.ec, claims))
+	assertInvalid(t, service, "EdDSA with RSA key", sign(t, "EdDSA", "rsa", k.ed, claims))
+
+	// The key published for RS256 only does not verify PS256, even if the signature matches
+	assertInvalid(t, service, "PS256 with RS256 key", sign(t, "PS256", "rs256", k.rsaOnly, claims))
+
+	if _, err := service.VerifyToken(context.Background(), sign(t, "RS256", "rs256", k.rsaOnly, claims)); err != nil {
+		t.Errorf("RS256 with RS256 key: %v", err)
+	}
+
+	// Keys meant for encryption do not verify signatures
+	assertInvalid(t, service, "encryption key", sign(t, "RS256", "enc", k.rsa, claims))
+}
+
+func TestJWTRejectsUnsignedAndSymmetricTokens(t *testing.T) {
+	k := generateKeys(t)
+	service := newTestJWTService(t, jwksDocument(k))
+	payload, _ := json.Marshal(validClaims())
+
+	for _, header := range []string{`{"alg":"none","kid":"rsa"}`, `{"alg":"None","kid":"rsa"}`, `{"alg":"none"}`} {
+		token := encode([]byte(header)) + "." + encode(payload) + "."
+		assertInvalid(t, service, header, token)
+	}
+
+	// HS256 keyed with the public key, as confused verifiers would check it
+	signed := encode([]byte(`{"alg":"HS256","kid":"rsa"}`)) + "." + encode(payload)
+	mac := hmac.New(sha256.New, k.rsa.PublicKey.N.Bytes())
+	mac.Write([]byte(signed))
+	assertInvalid(t, service, "HS256", signed+"."+encode(mac.Sum(nil)))
+}
+
+func TestJWTChecksClaimsWithClockSkew(t *testing.T) {
+	k := generateKeys(t)
+	service := newTestJWTService(t, jwksDocument(k))
+	now := time.Now()
+
+	valid := map[string]map[string]interface{}{
+		"expired within skew":       with(validClaims(), "exp", now.Add(-10*time.Second).Unix()),
+		"not before within skew":    with(validClaims(), "nbf", now.Add(10*time.Second).Unix()),
+		"issued within skew":        with(validClaims(), "iat", now.Add(10*time.Second).Unix()),
+		"audience in list":          with(validClaims(), "aud", []string{"other", "api-gateway"}),
+		"scopes as scp":             with(with(validClaims(), "scope", nil), "scp", []string{"orders:read"}),
+		"client in authorized part": with(with(validClaims(), "client_id", nil), "azp", "web"),
+	}
+
+	for name, claims := range valid {
+		if _, err := service.VerifyToken(context.Background(), sign(t, "ES256", "ec", k.ec, claims)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"expired":             with(validClaims(), "exp", now.Add(-time.Minute).Unix()),
+		"no expiration":       with(validClaims(), "exp", nil),
+		"not valid yet":       with(validClaims(), "nbf", now.Add(time.Minute).Unix()),
+		"issued in future":    with(validClaims(), "iat", now.Add(time.Minute).Unix()),
+		"wrong issuer":        with(validClaims(), "iss", "someone_else"),
+		"no issuer":           with(validClaims(), "iss", nil),
+		"wrong audience":      with(validClaims(), "aud", "other"),
+		"wrong audience list": with(validClaims(), "aud", []string{"other", "another"}),
+		"no audience":         with(validClaims(), "aud", nil),
+	}
+
+	for name, claims := range invalid {
+		assertInvalid(t, service, name, sign(t, "ES256", "ec", k.ec, claims))
+	}
+}
+
+func TestJWTRejectsTamperedTokens(t *testing.T) {
+	k := generateKeys(t)
+	service := newTestJWTService(t, jwksDocument(k))
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		kid, key := map[string]string{"RS256": "rsa", "ES256": "ec", "EdDSA": "ed"}[alg],
+			map[string]crypto.Signer{"RS256": k.rsa, "ES256": k.ec, "EdDSA": k.ed}[alg]
+		parts := strings.Split(sign(t, alg, kid, key, validClaims()), ".")
+
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		signature[len(signature)/2] ^= 0x01
+		assertInvalid(t, service, alg+" signature", parts[0]+"."+parts[1]+"."+encode(signature))
+
+		payload, _ := json.Marshal(with(validClaims(), "sub", "mallory"))
+		assertInvalid(t, service, alg+" claims", parts[0]+"."+encode(payload)+"."+parts[2])
+
+		assertInvalid(t, service, alg+" truncated", parts[0]+"."+parts[1])
+	}
+
+	assertInvalid(t, service, "garbage", "not-a-token")
+}
+
+func TestJWTRejectsUnknownKeys(t *testing.T) {
+	k := generateKeys(t)
+	service := newTestJWTService(t, jwksDocument(k))
+
+	assertInvalid(t, service, "unknown kid", sign(t, "RS256", "rotated", k.rsa, validClaims()))
+	assertInvalid(t, service, "no kid with several keys", sign(t, "RS256", "", k.rsa, validClaims()))
+}
+
+func TestJWKSReloadsForUnknownKeysOnceAtATime(t *testing.T) {
+	k := generateKeys(t)
+	var fetches int32
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first fetch loads the keys, reloads fail slowly
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			w.Write(jwksDocument(k))
+			return
+		}
+
+		<-release
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	keySet, err := NewKeySet("", server.URL, 0, log.NewNopLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := &JWTTokenService{keys: keySet, clockSkew: time.Second}
+	token := sign(t, "RS256", "forged", k.rsa, validClaims())
+
+	// Forged key ids share one reload, callers stop waiting when their context is done
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			assertInvalid(t, service, "forged kid", token)
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	begin := time.Now()
+
+	if _, ok := keySet.Key(ctx, "forged"); ok {
+		t.Error("forged key found")
+	}
+
+	cancel()
+
+	if waited := time.Since(begin); waited > time.Second {
+		t.Errorf("cancelled caller waited %v for the reload", waited)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("JWKS fetched %d times, want the load and a single reload", n)
+	}
+
+	// A failed reload counts as well, the next one waits for minKeyReload
+	assertInvalid(t, service, "forged kid after failed reload", token)
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("JWKS fetched %d times after a failed reload, want 2", n)
+	}
+
+	if _, err := service.VerifyToken(context.Background(), sign(t, "RS256", "rsa", k.rsa, validClaims())); err != nil {
+		t.Errorf("known key: %v", err)
+	}
+}
@@ -1,11 +1,13 @@
 package services
 
 import (
+	"api-gateway"
 	"api-gateway/data"
 	"context"
 	"fmt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/pkg/errors"
+	"time"
 )
 
 type TokenProxyService struct {
@@ -13,12 +15,14 @@ type TokenProxyService struct {
 	VerifyTokenEndpoint endpoint.Endpoint
 	RevokeTokenEndpoint endpoint.Endpoint
 	HealthCheckEndpoint endpoint.Endpoint
+	// Healthy reports whether the token service can be reached
+	Healthy func() bool
 }
 
 func (proxy TokenProxyService) IssueToken(ctx context.Context, login, password string) (string, error) {
 	r, err := proxy.IssueTokenEndpoint(ctx, data.LoginRequest{
-		login,
-		password,
+		Login:    login,
+		Password: password,
 	})
 
 	if err != nil {
@@ -38,31 +42,45 @@ func (proxy TokenProxyService) IssueToken(ctx context.Context, login, password s
 	return resp.Token, nil
 }
 
-func (proxy TokenProxyService) VerifyToken(ctx context.Context, token string) error {
+func (proxy TokenProxyService) VerifyToken(ctx context.Context, token string) (api_gateway.Identity, error) {
 	r, err := proxy.VerifyTokenEndpoint(ctx, data.VerifyTokenRequest{
-		token,
+		Token: token,
 	})
 
 	if err != nil {
-		return err
+		return api_gateway.Identity{}, err
 	}
 
 	resp, ok := r.(data.VerifyTokenResponse)
 
 	if !ok {
-		return errors.New(fmt.Sprintf("Error while converting response %v to VerifyTokenResponse", r))
+		return api_gateway.Identity{}, errors.New(fmt.Sprintf("Error while converting response %v to VerifyTokenResponse", r))
 	}
 
 	if len(resp.Error) > 0 {
-		return errors.New(resp.Error)
+		return api_gateway.Identity{}, api_gateway.InvalidTokenError{Reason: resp.Error}
 	}
 
-	return nil
+	identity := api_gateway.Identity{
+		Subject:  resp.Subject,
+		Scopes:   resp.Scopes,
+		ClientID: resp.ClientID,
+	}
+
+	if resp.IssuedAt > 0 {
+		identity.IssuedAt = time.Unix(resp.IssuedAt, 0)
+	}
+
+	if resp.ExpiresAt > 0 {
+		identity.ExpiresAt = time.Unix(resp.ExpiresAt, 0)
+	}
+
+	return identity, nil
 }
 
 func (proxy TokenProxyService) RevokeToken(ctx context.Context, token string) error {
 	r, err := proxy.RevokeTokenEndpoint(ctx, data.RevokeTokenRequest{
-		token,
+		Token: token,
 	})
 
 	if err != nil {
@@ -83,5 +101,5 @@ func (proxy TokenProxyService) RevokeToken(ctx context.Context, token string) er
 }
 
 func (proxy TokenProxyService) HealthCheck() bool {
-	return true
+	return proxy.Healthy == nil || proxy.Healthy()
 }
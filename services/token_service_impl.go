@@ -1,6 +1,9 @@
 package services
 
-import "context"
+import (
+	"api-gateway"
+	"context"
+)
 
 type TokenServiceImpl struct{}
 
@@ -8,8 +11,8 @@ func (tokenService TokenServiceImpl) IssueToken(ctx context.Context, login, pass
 	return "", nil
 }
 
-func (tokenService TokenServiceImpl) VerifyToken(ctx context.Context, token string) error {
-	return nil
+func (tokenService TokenServiceImpl) VerifyToken(ctx context.Context, token string) (api_gateway.Identity, error) {
+	return api_gateway.Identity{}, nil
 }
 
 func (tokenService TokenServiceImpl) RevokeToken(ctx context.Context, token string) error {
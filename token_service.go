@@ -1,10 +1,34 @@
 package api_gateway
 
-import "context"
+import (
+	"context"
+	"time"
+)
+
+// Identity is who a verified token was issued to and what it allows, ClientID is the
+// client the token was issued for. Times are zero when they are not known.
+type Identity struct {
+	Subject   string
+	Scopes    []string
+	ClientID  string
+	Issuer    string
+	Audiences []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
 
 type TokenService interface {
 	IssueToken(context.Context, string, string) (string, error)
-	VerifyToken(context.Context, string) error
+	VerifyToken(context.Context, string) (Identity, error)
 	RevokeToken(context.Context, string) error
 	HealthCheck() bool
 }
+
+// InvalidTokenError is returned when the token service rejects a token.
+type InvalidTokenError struct {
+	Reason string
+}
+
+func (e InvalidTokenError) Error() string {
+	return "invalid token: " + e.Reason
+}
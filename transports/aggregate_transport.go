@@ -0,0 +1,22 @@
+package transports
+
+import (
+	. "api-gateway/data"
+	"context"
+	"net/http"
+)
+
+func DecodeAggregateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	header := r.Header.Clone()
+	RemoveHopHeaders(header)
+	header.Del("Content-Length")
+	header.Del("Content-Type")
+
+	// Calls decode JSON bodies which the client must not ask to be compressed
+	header.Del("Accept-Encoding")
+
+	return AggregateRequest{
+		Query:  r.URL.Query(),
+		Header: header,
+	}, nil
+}
@@ -0,0 +1,40 @@
+package transports
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeAggregateRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/dashboard?user=42", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Connection", "keep-alive")
+	r.Header.Set("Authorization", "Bearer token")
+
+	decoded, err := DecodeAggregateRequest(context.Background(), r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := decoded.(AggregateRequest)
+
+	for _, name := range []string{"Accept-Encoding", "Content-Type", "Connection"} {
+		if _, ok := request.Header[name]; ok {
+			t.Errorf("%s is forwarded to calls", name)
+		}
+	}
+
+	if request.Header.Get("Authorization") != "Bearer token" || request.Query.Get("user") != "42" {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	if r.Header.Get("Accept-Encoding") != "gzip" {
+		t.Error("incoming request header is modified")
+	}
+}
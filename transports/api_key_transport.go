@@ -0,0 +1,71 @@
+package transports
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// RequestError is returned for requests that can not be decoded, it is reported as 400.
+type RequestError struct {
+	Reason string
+}
+
+func (e RequestError) Error() string {
+	return "bad request: " + e.Reason
+}
+
+func (e RequestError) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func DecodeCreateAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var createRequest CreateAPIKeyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
+		return nil, RequestError{err.Error()}
+	}
+
+	return createRequest, nil
+}
+
+func DecodeListAPIKeysRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return ListAPIKeysRequest{}, nil
+}
+
+// DecodeRotateAPIKeyRequest takes the key id from paths ending with "/<id>/rotate".
+func DecodeRotateAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	segments := pathSegments(r.URL.Path)
+
+	if len(segments) < 2 || segments[len(segments)-1] != "rotate" {
+		return nil, RequestError{"path must end with /<id>/rotate"}
+	}
+
+	return RotateAPIKeyRequest{ID: segments[len(segments)-2]}, nil
+}
+
+// DecodeRevokeAPIKeyRequest takes the key id from the last path segment.
+func DecodeRevokeAPIKeyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	segments := pathSegments(r.URL.Path)
+
+	if len(segments) == 0 {
+		return nil, RequestError{"path must end with /<id>"}
+	}
+
+	return RevokeAPIKeyRequest{ID: segments[len(segments)-1]}, nil
+}
+
+func pathSegments(path string) []string {
+	var segments []string
+
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) > 0 {
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
@@ -0,0 +1,43 @@
+package transports
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeRotateAPIKeyRequest(t *testing.T) {
+	valid := map[string]string{
+		"/admin/keys/k1/rotate":  "k1",
+		"/admin/keys/k1/rotate/": "k1",
+	}
+
+	for path, id := range valid {
+		request, err := DecodeRotateAPIKeyRequest(context.Background(), httptest.NewRequest(http.MethodPost, path, nil))
+
+		if err != nil || request.(RotateAPIKeyRequest).ID != id {
+			t.Errorf("%s: got %v, %v", path, request, err)
+		}
+	}
+
+	for _, path := range []string{"/rotate", "/admin/keys/k1", "/admin/keys/k1/revoke"} {
+		if _, err := DecodeRotateAPIKeyRequest(context.Background(), httptest.NewRequest(http.MethodPost, path, nil)); err == nil {
+			t.Errorf("%s is decoded", path)
+		}
+	}
+}
+
+func TestDecodeRevokeAPIKeyRequest(t *testing.T) {
+	request, err := DecodeRevokeAPIKeyRequest(context.Background(), httptest.NewRequest(http.MethodDelete, "/admin/keys/k1", nil))
+
+	if err != nil || request.(RevokeAPIKeyRequest).ID != "k1" {
+		t.Errorf("got %v, %v", request, err)
+	}
+
+	if _, err := DecodeRevokeAPIKeyRequest(context.Background(), httptest.NewRequest(http.MethodDelete, "/", nil)); err == nil {
+		t.Error("path without id is decoded")
+	}
+}
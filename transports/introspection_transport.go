@@ -0,0 +1,34 @@
+package transports
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"net/http"
+)
+
+// DecodeIntrospectionRequest reads the form posted by RFC 7662 clients.
+func DecodeIntrospectionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, RequestError{err.Error()}
+	}
+
+	token := r.PostForm.Get("token")
+
+	if len(token) == 0 {
+		return nil, RequestError{"token is missing"}
+	}
+
+	return IntrospectionRequest{
+		Token:         token,
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+	}, nil
+}
+
+// EncodeIntrospectionResponse writes the response so that it is not cached, as it describes a token.
+func EncodeIntrospectionResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Cache-Control", "no-store")
+
+	return httptransport.EncodeJSONResponse(ctx, w, response)
+}
@@ -0,0 +1,13 @@
+package transports
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"net/http"
+)
+
+// decode request for the ejected upstream instances
+func DecodeOutliersRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return OutliersRequest{}, nil
+}
@@ -0,0 +1,98 @@
+package transports
+
+import (
+	. "api-gateway/data"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Hop-by-hop headers are meaningful only for a single connection and must not be forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders deletes hop-by-hop headers including the ones listed in Connection.
+func RemoveHopHeaders(header http.Header) {
+	for _, value := range header["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+func DecodeProxyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	header := r.Header.Clone()
+	RemoveHopHeaders(header)
+
+	proto := "http"
+
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	return ProxyRequest{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		RawPath:       r.URL.RawPath,
+		RawQuery:      r.URL.RawQuery,
+		Header:        header,
+		Body:          r.Body,
+		ContentLength: r.ContentLength,
+		Host:          r.Host,
+		RemoteAddr:    r.RemoteAddr,
+		Proto:         proto,
+	}, nil
+}
+
+// EncodeProxyResponse streams the upstream response to the client flushing as data arrives.
+func EncodeProxyResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(ProxyResponse)
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	RemoveHopHeaders(w.Header())
+	w.WriteHeader(resp.StatusCode)
+
+	var writer io.Writer = w
+
+	if flusher, ok := w.(http.Flusher); ok {
+		writer = flushWriter{w, flusher}
+	}
+
+	_, err := io.Copy(writer, resp.Body)
+
+	return err
+}
+
+type flushWriter struct {
+	io.Writer
+	flusher http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.Writer.Write(p)
+	fw.flusher.Flush()
+
+	return n, err
+}
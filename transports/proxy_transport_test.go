@@ -0,0 +1,65 @@
+package transports
+
+import (
+	. "api-gateway/data"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeProxyRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "http://api.example.com/files/a%2Fb/raw?x=1&y=%20", nil)
+	r.Header.Set("Connection", "X-Trace")
+	r.Header.Set("X-Trace", "1")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("Accept", "text/plain")
+
+	decoded, err := DecodeProxyRequest(context.Background(), r)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := decoded.(ProxyRequest)
+
+	if request.Path != "/files/a/b/raw" || request.RawPath != "/files/a%2Fb/raw" {
+		t.Errorf("path %q, raw path %q", request.Path, request.RawPath)
+	}
+
+	if request.RawQuery != "x=1&y=%20" {
+		t.Errorf("query %q", request.RawQuery)
+	}
+
+	if request.Method != http.MethodPost || request.Host != "api.example.com" || request.Proto != "http" {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	for _, name := range []string{"Connection", "X-Trace", "Keep-Alive"} {
+		if _, ok := request.Header[name]; ok {
+			t.Errorf("hop header %s is forwarded", name)
+		}
+	}
+
+	if request.Header.Get("Accept") != "text/plain" {
+		t.Error("end-to-end header is dropped")
+	}
+
+	request.Header.Set("Accept", "application/json")
+
+	if r.Header.Get("Accept") != "text/plain" {
+		t.Error("decoded header shares storage with the incoming request")
+	}
+}
+
+func TestDecodeProxyRequestPlainPath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://api.example.com/files/a%20b", nil)
+
+	decoded, _ := DecodeProxyRequest(context.Background(), r)
+	request := decoded.(ProxyRequest)
+
+	if request.Path != "/files/a b" || len(request.RawPath) > 0 {
+		t.Errorf("path %q, raw path %q", request.Path, request.RawPath)
+	}
+}
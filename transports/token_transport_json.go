@@ -4,9 +4,23 @@ import (
 	. "api-gateway/data"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 )
 
+// StatusError is returned when the upstream replies with a server error.
+type StatusError struct {
+	Code int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("upstream replied %d", e.Code)
+}
+
+func (e StatusError) StatusCode() int {
+	return e.Code
+}
+
 func DecodeIssueTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var issueTokenRequest LoginRequest
 
@@ -44,6 +58,10 @@ func EncodeResponse(_ context.Context, w http.ResponseWriter, response interface
 func DecodeIssueTokenResponse(_ context.Context, r *http.Response) (response interface{}, err error) {
 	var issueTokenResponse IssueTokenResponse
 
+	if r.StatusCode >= http.StatusInternalServerError {
+		return nil, StatusError{r.StatusCode}
+	}
+
 	if err := json.NewDecoder(r.Body).Decode(&issueTokenResponse); err != nil {
 		return nil, err
 	}
@@ -54,6 +72,10 @@ func DecodeIssueTokenResponse(_ context.Context, r *http.Response) (response int
 func DecodeVerifyTokenResponse(_ context.Context, r *http.Response) (response interface{}, err error) {
 	var verifyTokenResponse VerifyTokenResponse
 
+	if r.StatusCode >= http.StatusInternalServerError {
+		return nil, StatusError{r.StatusCode}
+	}
+
 	if err := json.NewDecoder(r.Body).Decode(&verifyTokenResponse); err != nil {
 		return nil, err
 	}
@@ -64,6 +86,10 @@ func DecodeVerifyTokenResponse(_ context.Context, r *http.Response) (response in
 func DecodeRevokeTokenResponse(_ context.Context, r *http.Response) (response interface{}, err error) {
 	var revokeTokenResponse RevokeTokenResponse
 
+	if r.StatusCode >= http.StatusInternalServerError {
+		return nil, StatusError{r.StatusCode}
+	}
+
 	if err := json.NewDecoder(r.Body).Decode(&revokeTokenResponse); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,39 @@
+package upstreams
+
+import (
+	. "api-gateway"
+	. "api-gateway/middleware"
+)
+
+const (
+	defaultLimitMinLimit  = 1
+	defaultLimitTolerance = 2
+	defaultLimitBackoff   = 0.9
+)
+
+// newAdaptiveLimiter makes the limiter shared by all endpoints of an upstream,
+// it returns nil when adaptive limiting is disabled.
+func newAdaptiveLimiter(name string, config AdaptiveLimitConfig, metrics *Metrics) *AdaptiveLimiter {
+	if config.MaxLimit <= 0 {
+		return nil
+	}
+
+	if config.MinLimit <= 0 {
+		config.MinLimit = defaultLimitMinLimit
+	}
+
+	if config.InitialLimit <= 0 || config.InitialLimit > config.MaxLimit {
+		config.InitialLimit = config.MaxLimit
+	}
+
+	if config.Tolerance < 1 {
+		config.Tolerance = defaultLimitTolerance
+	}
+
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = defaultLimitBackoff
+	}
+
+	return NewAdaptiveLimiter(name, config.InitialLimit, config.MinLimit, config.MaxLimit, config.Tolerance,
+		config.Backoff, metrics.ConcurrencyLimit.With("upstream", name), metrics.Limited.With("upstream", name))
+}
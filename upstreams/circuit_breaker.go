@@ -0,0 +1,34 @@
+package upstreams
+
+import (
+	. "api-gateway"
+
+	"github.com/go-kit/kit/log"
+	"github.com/sony/gobreaker"
+)
+
+// newCircuitBreaker makes the breaker shared by all endpoints of an upstream,
+// it returns nil when the breaker is disabled.
+func newCircuitBreaker(name string, config CircuitBreakerConfig, metrics *Metrics, logger log.Logger) *gobreaker.CircuitBreaker {
+	if config.FailureRatio <= 0 {
+		return nil
+	}
+
+	metrics.BreakerState.With("upstream", name).Set(float64(gobreaker.StateClosed))
+
+	return gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        name,
+		MaxRequests: config.HalfOpenRequests,
+		Interval:    config.Interval.Duration,
+		Timeout:     config.OpenDuration.Duration,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.Requests >= config.MinRequests &&
+				float64(counts.TotalFailures)/float64(counts.Requests) >= config.FailureRatio
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			logger.Log("msg", "circuit breaker state changed", "from", from, "to", to)
+			metrics.BreakerState.With("upstream", name).Set(float64(to))
+			metrics.BreakerTransitions.With("upstream", name, "from", from.String(), "to", to.String()).Add(1)
+		},
+	})
+}
@@ -0,0 +1,263 @@
+package upstreams
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+	. "api-gateway/middleware"
+
+	"context"
+	"crypto/tls"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/consul"
+	"github.com/pkg/errors"
+	"github.com/sony/gobreaker"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoInstances is returned when a cluster has no instance to send a request to.
+var ErrNoInstances = noInstancesError{}
+
+type noInstancesError struct{}
+
+func (noInstancesError) Error() string {
+	return "no upstream instances available"
+}
+
+func (noInstancesError) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// Rejected keeps the adaptive limiter from taking a missing instance for a slow one.
+func (noInstancesError) Rejected() {}
+
+// EndpointFactory makes an endpoint calling a single upstream instance at target.
+type EndpointFactory func(target *url.URL, client *http.Client) endpoint.Endpoint
+
+// Cluster is a named group of interchangeable upstream instances.
+type Cluster struct {
+	Name      string
+	Client    *http.Client
+	config    UpstreamConfig
+	instancer sd.Instancer
+	balancer  balancerFactory
+	breaker   *gobreaker.CircuitBreaker
+	bulkhead  *Bulkhead
+	limiter   *AdaptiveLimiter
+	detector  *OutlierDetector
+	checker   *HealthChecker
+	logger    log.Logger
+
+	mtx         sync.Mutex
+	endpointers []*instanceEndpointer
+}
+
+// NewCluster makes a cluster, consulClient is used only by clusters discovered through consul.
+func NewCluster(config UpstreamConfig, consulClient consul.Client, metrics *Metrics, logger log.Logger) (*Cluster, error) {
+	if len(config.Name) == 0 {
+		return nil, errors.New("upstream name must be set")
+	}
+
+	if len(config.Scheme) == 0 {
+		config.Scheme = "http"
+	}
+
+	logger = log.With(logger, "upstream", config.Name)
+	instancer, err := newInstancer(config, consulClient, logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	balancer, err := newBalancerFactory(config.Balancer)
+
+	if err != nil {
+		stopInstancer(instancer)
+		return nil, err
+	}
+
+	client := newClient(config.Client)
+
+	return &Cluster{
+		Name:      config.Name,
+		Client:    client,
+		config:    config,
+		instancer: instancer,
+		balancer:  balancer,
+		breaker:   newCircuitBreaker(config.Name, config.CircuitBreaker, metrics, logger),
+		bulkhead:  newBulkhead(config.Name, config.Bulkhead, metrics),
+		limiter:   newAdaptiveLimiter(config.Name, config.AdaptiveLimit, metrics),
+		detector:  newOutlierDetector(config.Name, config.OutlierDetection, metrics, logger),
+		checker:   newHealthChecker(config, client, instancer, metrics, logger),
+		logger:    logger,
+	}, nil
+}
+
+// URL makes the address of path on the given instance.
+func (cluster *Cluster) URL(instance, requestPath string) *url.URL {
+	return &url.URL{
+		Scheme: cluster.config.Scheme,
+		Host:   instance,
+		Path:   path.Join("/", cluster.config.BasePath, requestPath),
+	}
+}
+
+// Endpoint makes an endpoint that spreads calls to path across the cluster instances,
+// the set of instances follows the changes reported by service discovery
+// and leaves out the instances failing health checks or ejected by outlier detection.
+// Retries and hedges go to instances earlier attempts of the request did not go to
+// while there are any.
+func (cluster *Cluster) Endpoint(requestPath string, factory EndpointFactory) endpoint.Endpoint {
+	endpointer := newInstanceEndpointer(cluster.instancer, func(instance string) endpoint.Endpoint {
+		e := factory(cluster.URL(instance, requestPath), cluster.Client)
+
+		if cluster.detector != nil {
+			e = cluster.detector.track(instance, e)
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			Attempted(ctx, instance)
+
+			return e(ctx, request)
+		}
+	}, cluster, cluster.logger)
+
+	cluster.mtx.Lock()
+	cluster.endpointers = append(cluster.endpointers, endpointer)
+	cluster.mtx.Unlock()
+
+	balancer := cluster.balancer(endpointer)
+	var next uint64
+
+	var e endpoint.Endpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+		e, err := balancer.Endpoint()
+
+		if err != nil {
+			return nil, ErrNoInstances
+		}
+
+		// Instances tried already are used again only when all of them were
+		if tried := AttemptedInstances(ctx); tried != nil {
+			if untried := endpointer.untried(tried); len(untried) > 0 {
+				e = untried[(atomic.AddUint64(&next, 1)-1)%uint64(len(untried))]
+			}
+		}
+
+		return e(ctx, request)
+	}
+
+	if cluster.breaker != nil {
+		e = CircuitBreakerMiddleware(cluster.breaker)(e)
+	}
+
+	// Calls rejected by the limiter or the bulkhead never reach the breaker, so they do not trip it,
+	// and calls the breaker rejects do not lower the limit
+	if cluster.limiter != nil {
+		e = AdaptiveLimitMiddleware(cluster.limiter)(e)
+	}
+
+	if cluster.bulkhead != nil {
+		e = BulkheadMiddleware(cluster.bulkhead)(e)
+	}
+
+	return e
+}
+
+// newBulkhead makes the bulkhead shared by all endpoints of an upstream,
+// it returns nil when the bulkhead is disabled.
+func newBulkhead(name string, config BulkheadConfig, metrics *Metrics) *Bulkhead {
+	if config.MaxInFlight <= 0 {
+		return nil
+	}
+
+	return NewBulkhead(name, config.MaxInFlight, config.MaxQueued, config.QueueTimeout.Duration,
+		config.RetryAfter.Duration, metrics.InFlight.With("upstream", name), metrics.Queued.With("upstream", name))
+}
+
+func (cluster *Cluster) update(instances []string) {
+	if cluster.detector != nil {
+		cluster.detector.update(instances)
+	}
+}
+
+func (cluster *Cluster) available(instance string) bool {
+	if cluster.checker != nil && !cluster.checker.Healthy(instance) {
+		return false
+	}
+
+	return cluster.detector == nil || !cluster.detector.Ejected(instance)
+}
+
+// Healthy reports whether any instance passes health checks,
+// clusters without health checks are always healthy.
+func (cluster *Cluster) Healthy() bool {
+	return cluster.checker == nil || cluster.checker.Health().Status
+}
+
+// Ejections lists the instances of the cluster ejected by outlier detection.
+func (cluster *Cluster) Ejections() []EjectedInstance {
+	if cluster.detector == nil {
+		return nil
+	}
+
+	return cluster.detector.Ejections()
+}
+
+// Close stops following service discovery.
+func (cluster *Cluster) Close() {
+	cluster.mtx.Lock()
+	defer cluster.mtx.Unlock()
+
+	for _, endpointer := range cluster.endpointers {
+		endpointer.Close()
+	}
+
+	cluster.endpointers = nil
+
+	if cluster.checker != nil {
+		cluster.checker.Stop()
+	}
+
+	stopInstancer(cluster.instancer)
+}
+
+// stopInstancer stops watching consul, other instancers have nothing to stop.
+func stopInstancer(instancer sd.Instancer) {
+	if instancer, ok := instancer.(*consul.Instancer); ok {
+		instancer.Stop()
+	}
+}
+
+func newClient(config UpstreamClientConfig) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout.Duration,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout.Duration,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout.Duration,
+	}
+
+	if config.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout.Duration,
+		// Redirects are handed back to the caller as is
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,218 @@
+package upstreams
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+	. "api-gateway/middleware"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testMetrics = NewMetrics("upstreams_test")
+
+// failingFactory makes endpoints answering 503 and counting the calls of every instance.
+type failingFactory struct {
+	mtx  sync.Mutex
+	hits map[string]int
+}
+
+func (f *failingFactory) make(target *url.URL, _ *http.Client) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		f.mtx.Lock()
+		f.hits[target.Host]++
+		f.mtx.Unlock()
+
+		return ProxyResponse{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+}
+
+func TestRetriesGoToOtherInstances(t *testing.T) {
+	instances := []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}
+
+	for _, balancer := range []string{RandomBalancer, RoundRobinBalancer} {
+		cluster, err := NewCluster(UpstreamConfig{
+			Name:      "retry_" + balancer,
+			Addresses: instances,
+			Balancer:  balancer,
+		}, nil, testMetrics, log.NewNopLogger())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		factory := &failingFactory{hits: make(map[string]int)}
+		e := RetryMiddleware(RetryPolicy{
+			MaxAttempts:          len(instances),
+			RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		}, discard.NewCounter(), log.NewNopLogger())(cluster.Endpoint("/", factory.make))
+
+		for i := 0; i < 20; i++ {
+			if _, err := e(context.Background(), ProxyRequest{Method: http.MethodGet, Header: http.Header{}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, instance := range instances {
+			if hits := factory.hits[instance]; hits != 20 {
+				t.Errorf("%s: instance %s got %d attempts, want one per request", balancer, instance, hits)
+			}
+		}
+
+		cluster.Close()
+	}
+}
+
+func TestAttemptsReuseInstancesWhenAllWereTried(t *testing.T) {
+	cluster, err := NewCluster(UpstreamConfig{
+		Name:      "single",
+		Addresses: []string{"10.0.0.1:80"},
+	}, nil, testMetrics, log.NewNopLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	factory := &failingFactory{hits: make(map[string]int)}
+	e := RetryMiddleware(RetryPolicy{
+		MaxAttempts:          3,
+		RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+	}, discard.NewCounter(), log.NewNopLogger())(cluster.Endpoint("/", factory.make))
+
+	if _, err := e(context.Background(), ProxyRequest{Method: http.MethodGet, Header: http.Header{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if hits := factory.hits["10.0.0.1:80"]; hits != 3 {
+		t.Errorf("only instance got %d attempts, want 3", hits)
+	}
+}
+
+func TestHedgesGoToAnotherInstance(t *testing.T) {
+	cluster, err := NewCluster(UpstreamConfig{
+		Name:      "hedge",
+		Addresses: []string{"10.0.0.1:80", "10.0.0.2:80"},
+		Balancer:  RandomBalancer,
+	}, nil, testMetrics, log.NewNopLogger())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	var (
+		mtx   sync.Mutex
+		calls []string
+	)
+
+	slow := func(target *url.URL, _ *http.Client) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			mtx.Lock()
+			calls = append(calls, target.Host)
+			mtx.Unlock()
+
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-ctx.Done():
+			}
+
+			return ProxyResponse{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader("")),
+			}, nil
+		}
+	}
+
+	e := HedgeMiddleware(HedgePolicy{
+		Delay:        5 * time.Millisecond,
+		MaxPercent:   100,
+		MaxBodyBytes: 1024,
+	}, discard.NewCounter())(cluster.Endpoint("/", slow))
+
+	// The first request is never hedged, there is no hedging budget yet
+	for i := 0; i < 11; i++ {
+		mtx.Lock()
+		calls = nil
+		mtx.Unlock()
+
+		response, err := e(context.Background(), ProxyRequest{Method: http.MethodGet, Header: http.Header{}})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response.(ProxyResponse).Body.Close()
+		mtx.Lock()
+
+		if i == 0 {
+			mtx.Unlock()
+			continue
+		}
+
+		if len(calls) != 2 {
+			t.Errorf("request was sent %d times, want a hedge", len(calls))
+		} else if calls[0] == calls[1] {
+			t.Errorf("hedge went to %s again", calls[0])
+		}
+
+		mtx.Unlock()
+	}
+}
+
+func TestMissingInstancesDoNotLowerTheLimit(t *testing.T) {
+	limiter := NewAdaptiveLimiter("test", 10, 1, 100, 2, 0.5, discard.NewGauge(), discard.NewCounter())
+	missing := AdaptiveLimitMiddleware(limiter)(func(context.Context, interface{}) (interface{}, error) {
+		return nil, ErrNoInstances
+	})
+
+	for i := 0; i < 5; i++ {
+		if _, err := missing(context.Background(), nil); err != ErrNoInstances {
+			t.Fatalf("got %v", err)
+		}
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+
+	blocking := AdaptiveLimitMiddleware(limiter)(func(context.Context, interface{}) (interface{}, error) {
+		<-release
+		return nil, nil
+	})
+
+	// The limit is full once calls are rejected, it must still be the initial one
+	for i := 0; ; i++ {
+		rejected := make(chan error, 1)
+
+		go func() {
+			_, err := blocking(context.Background(), nil)
+			rejected <- err
+		}()
+
+		select {
+		case err := <-rejected:
+			if limitErr, ok := err.(LimitExceededError); !ok || limitErr.Limit != 10 || i != 10 {
+				t.Fatalf("call %d got %v", i, err)
+			}
+
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package upstreams
+
+import (
+	. "api-gateway"
+
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/consul"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+const (
+	StaticDiscovery = "static"
+	ConsulDiscovery = "consul"
+
+	RoundRobinBalancer = "round_robin"
+	RandomBalancer     = "random"
+)
+
+// newInstancer makes the source of instances of an upstream cluster.
+func newInstancer(config UpstreamConfig, client consul.Client, logger log.Logger) (sd.Instancer, error) {
+	switch config.Discovery {
+	case "", StaticDiscovery:
+		if len(config.Addresses) == 0 {
+			return nil, errors.New(fmt.Sprintf("upstream %s has no addresses", config.Name))
+		}
+
+		return sd.FixedInstancer(config.Addresses), nil
+	case ConsulDiscovery:
+		if client == nil {
+			return nil, errors.New(fmt.Sprintf("upstream %s needs consul but it is not configured", config.Name))
+		}
+
+		service := config.ServiceName
+
+		if len(service) == 0 {
+			service = config.Name
+		}
+
+		return consul.NewInstancer(client, logger, service, config.Tags, config.PassingOnly), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown discovery %q", config.Discovery))
+	}
+}
+
+type balancerFactory func(endpointer sd.Endpointer) lb.Balancer
+
+// newBalancerFactory picks the strategy used to choose an instance for every request.
+func newBalancerFactory(name string) (balancerFactory, error) {
+	switch name {
+	case "", RoundRobinBalancer:
+		return lb.NewRoundRobin, nil
+	case RandomBalancer:
+		return func(endpointer sd.Endpointer) lb.Balancer {
+			return &lockedBalancer{balancer: lb.NewRandom(endpointer, time.Now().UnixNano())}
+		}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown balancer %q", name))
+	}
+}
+
+// lockedBalancer serializes a balancer that is not safe for concurrent use,
+// the random balancer shares a source of random numbers between calls.
+type lockedBalancer struct {
+	mtx      sync.Mutex
+	balancer lb.Balancer
+}
+
+func (balancer *lockedBalancer) Endpoint() (endpoint.Endpoint, error) {
+	balancer.mtx.Lock()
+	defer balancer.mtx.Unlock()
+
+	return balancer.balancer.Endpoint()
+}
@@ -0,0 +1,122 @@
+package upstreams
+
+import (
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"sort"
+	"sync"
+)
+
+// instanceFilter decides which instances take part in balancing.
+type instanceFilter interface {
+	update(instances []string)
+	available(instance string) bool
+}
+
+// instanceEndpointer follows the instances of a cluster and keeps an endpoint for each
+// of them, instances the filter does not find available are left out of balancing.
+type instanceEndpointer struct {
+	instancer sd.Instancer
+	factory   func(instance string) endpoint.Endpoint
+	filter    instanceFilter
+	logger    log.Logger
+	events    chan sd.Event
+
+	mtx       sync.RWMutex
+	instances []string
+	endpoints map[string]endpoint.Endpoint
+}
+
+func newInstanceEndpointer(instancer sd.Instancer, factory func(instance string) endpoint.Endpoint,
+	filter instanceFilter, logger log.Logger) *instanceEndpointer {
+	endpointer := &instanceEndpointer{
+		instancer: instancer,
+		factory:   factory,
+		filter:    filter,
+		logger:    logger,
+		events:    make(chan sd.Event),
+		endpoints: make(map[string]endpoint.Endpoint),
+	}
+
+	go endpointer.receive()
+	instancer.Register(endpointer.events)
+
+	return endpointer
+}
+
+func (endpointer *instanceEndpointer) receive() {
+	for event := range endpointer.events {
+		endpointer.update(event)
+	}
+}
+
+// update keeps the endpoints of the instances that are still there, previously known
+// instances are used as long as service discovery fails.
+func (endpointer *instanceEndpointer) update(event sd.Event) {
+	if event.Err != nil {
+		endpointer.logger.Log("msg", "service discovery failed, keeping known instances", "err", event.Err)
+		return
+	}
+
+	instances := append([]string(nil), event.Instances...)
+	sort.Strings(instances)
+
+	endpointer.mtx.Lock()
+	endpoints := make(map[string]endpoint.Endpoint, len(instances))
+
+	for _, instance := range instances {
+		if e, ok := endpointer.endpoints[instance]; ok {
+			endpoints[instance] = e
+		} else {
+			endpoints[instance] = endpointer.factory(instance)
+		}
+	}
+
+	endpointer.instances, endpointer.endpoints = instances, endpoints
+	endpointer.mtx.Unlock()
+
+	endpointer.filter.update(instances)
+}
+
+// Endpoints implements sd.Endpointer.
+func (endpointer *instanceEndpointer) Endpoints() ([]endpoint.Endpoint, error) {
+	endpointer.mtx.RLock()
+	defer endpointer.mtx.RUnlock()
+
+	endpoints := make([]endpoint.Endpoint, 0, len(endpointer.instances))
+
+	for _, instance := range endpointer.instances {
+		if !endpointer.filter.available(instance) {
+			continue
+		}
+
+		endpoints = append(endpoints, endpointer.endpoints[instance])
+	}
+
+	return endpoints, nil
+}
+
+// untried returns the endpoints of the available instances not in tried.
+func (endpointer *instanceEndpointer) untried(tried map[string]bool) []endpoint.Endpoint {
+	endpointer.mtx.RLock()
+	defer endpointer.mtx.RUnlock()
+
+	var endpoints []endpoint.Endpoint
+
+	for _, instance := range endpointer.instances {
+		if tried[instance] || !endpointer.filter.available(instance) {
+			continue
+		}
+
+		endpoints = append(endpoints, endpointer.endpoints[instance])
+	}
+
+	return endpoints
+}
+
+// Close stops following the instancer.
+func (endpointer *instanceEndpointer) Close() {
+	endpointer.instancer.Deregister(endpointer.events)
+	close(endpointer.events)
+}
@@ -0,0 +1,285 @@
+package upstreams
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval  = 10 * time.Second
+	defaultHealthCheckTimeout   = 2 * time.Second
+	defaultHealthCheckThreshold = 2
+)
+
+type instanceStatus struct {
+	healthy   bool
+	successes int
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+// HealthChecker probes the instances of an upstream in the background. An instance
+// is marked unhealthy after UnhealthyThreshold failed probes in a row and healthy
+// again after HealthyThreshold successful ones. Instances are healthy until probed.
+type HealthChecker struct {
+	upstream  string
+	scheme    string
+	config    HealthCheckConfig
+	client    *http.Client
+	instancer sd.Instancer
+	metrics   *Metrics
+	logger    log.Logger
+	events    chan sd.Event
+	recheck   chan struct{}
+	stop      chan struct{}
+
+	mtx       sync.RWMutex
+	instances map[string]*instanceStatus
+}
+
+// newHealthChecker starts checking the instances of an upstream,
+// it returns nil when health checks are disabled.
+func newHealthChecker(config UpstreamConfig, client *http.Client, instancer sd.Instancer,
+	metrics *Metrics, logger log.Logger) *HealthChecker {
+	checkConfig := config.HealthCheck
+
+	if len(checkConfig.Path) == 0 {
+		return nil
+	}
+
+	if checkConfig.Interval.Duration <= 0 {
+		checkConfig.Interval.Duration = defaultHealthCheckInterval
+	}
+
+	if checkConfig.Timeout.Duration <= 0 {
+		checkConfig.Timeout.Duration = defaultHealthCheckTimeout
+	}
+
+	if checkConfig.HealthyThreshold <= 0 {
+		checkConfig.HealthyThreshold = defaultHealthCheckThreshold
+	}
+
+	if checkConfig.UnhealthyThreshold <= 0 {
+		checkConfig.UnhealthyThreshold = defaultHealthCheckThreshold
+	}
+
+	checker := &HealthChecker{
+		upstream:  config.Name,
+		scheme:    config.Scheme,
+		config:    checkConfig,
+		client:    client,
+		instancer: instancer,
+		metrics:   metrics,
+		logger:    logger,
+		events:    make(chan sd.Event),
+		recheck:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		instances: make(map[string]*instanceStatus),
+	}
+
+	go checker.receive()
+	go checker.run()
+	instancer.Register(checker.events)
+
+	return checker
+}
+
+// receive follows service discovery and has new instances checked right away,
+// probes are made by run so that slow ones do not hold up service discovery.
+func (checker *HealthChecker) receive() {
+	for event := range checker.events {
+		if event.Err != nil {
+			continue
+		}
+
+		checker.update(event.Instances)
+
+		select {
+		case checker.recheck <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (checker *HealthChecker) run() {
+	ticker := time.NewTicker(checker.config.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-checker.recheck:
+			checker.check()
+		case <-ticker.C:
+			checker.check()
+		case <-checker.stop:
+			return
+		}
+	}
+}
+
+// update follows the instances reported by service discovery.
+func (checker *HealthChecker) update(instances []string) {
+	checker.mtx.Lock()
+	defer checker.mtx.Unlock()
+
+	known := make(map[string]bool, len(instances))
+
+	for _, instance := range instances {
+		known[instance] = true
+
+		if _, ok := checker.instances[instance]; !ok {
+			checker.instances[instance] = &instanceStatus{healthy: true}
+			checker.metrics.Healthy.With("upstream", checker.upstream, "instance", instance).Set(1)
+		}
+	}
+
+	for instance := range checker.instances {
+		if !known[instance] {
+			checker.metrics.Healthy.With("upstream", checker.upstream, "instance", instance).Set(0)
+			delete(checker.instances, instance)
+		}
+	}
+}
+
+// check probes all instances at once and waits for the results.
+func (checker *HealthChecker) check() {
+	checker.mtx.RLock()
+	instances := make([]string, 0, len(checker.instances))
+
+	for instance := range checker.instances {
+		instances = append(instances, instance)
+	}
+
+	checker.mtx.RUnlock()
+
+	var wg sync.WaitGroup
+
+	for _, instance := range instances {
+		wg.Add(1)
+
+		go func(instance string) {
+			defer wg.Done()
+			checker.record(instance, checker.probe(instance))
+		}(instance)
+	}
+
+	wg.Wait()
+}
+
+func (checker *HealthChecker) probe(instance string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checker.config.Timeout.Duration)
+	defer cancel()
+
+	target := url.URL{Scheme: checker.scheme, Host: instance, Path: checker.config.Path}
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := checker.client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("health check replied %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (checker *HealthChecker) record(instance string, err error) {
+	checker.mtx.Lock()
+	defer checker.mtx.Unlock()
+
+	status, ok := checker.instances[instance]
+
+	if !ok {
+		return
+	}
+
+	status.lastCheck = time.Now()
+
+	if err != nil {
+		status.lastError = err.Error()
+		status.successes = 0
+		status.failures++
+
+		if status.healthy && status.failures >= checker.config.UnhealthyThreshold {
+			status.healthy = false
+			checker.metrics.Healthy.With("upstream", checker.upstream, "instance", instance).Set(0)
+			checker.logger.Log("msg", "instance is unhealthy", "instance", instance, "err", err)
+		}
+
+		return
+	}
+
+	status.lastError = ""
+	status.failures = 0
+	status.successes++
+
+	if !status.healthy && status.successes >= checker.config.HealthyThreshold {
+		status.healthy = true
+		checker.metrics.Healthy.With("upstream", checker.upstream, "instance", instance).Set(1)
+		checker.logger.Log("msg", "instance is healthy again", "instance", instance)
+	}
+}
+
+// Healthy reports whether instance passes its health checks.
+func (checker *HealthChecker) Healthy(instance string) bool {
+	checker.mtx.RLock()
+	defer checker.mtx.RUnlock()
+
+	status, ok := checker.instances[instance]
+
+	return !ok || status.healthy
+}
+
+// Health reports the instances of the upstream, it is healthy while any of them is.
+func (checker *HealthChecker) Health() UpstreamHealth {
+	checker.mtx.RLock()
+	defer checker.mtx.RUnlock()
+
+	health := UpstreamHealth{
+		Name:      checker.upstream,
+		Instances: make([]InstanceHealth, 0, len(checker.instances)),
+	}
+
+	for instance, status := range checker.instances {
+		health.Status = health.Status || status.healthy
+		health.Instances = append(health.Instances, InstanceHealth{
+			Address:   instance,
+			Healthy:   status.healthy,
+			LastCheck: status.lastCheck,
+			Error:     status.lastError,
+		})
+	}
+
+	sort.Slice(health.Instances, func(i, j int) bool {
+		return health.Instances[i].Address < health.Instances[j].Address
+	})
+
+	return health
+}
+
+// Stop stops checking the instances.
+func (checker *HealthChecker) Stop() {
+	checker.instancer.Deregister(checker.events)
+	close(checker.events)
+	close(checker.stop)
+}
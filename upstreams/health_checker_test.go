@@ -0,0 +1,97 @@
+package upstreams
+
+import (
+	. "api-gateway"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func newTestHealthChecker(healthyThreshold, unhealthyThreshold int) *HealthChecker {
+	checker := &HealthChecker{
+		upstream: "test",
+		config: HealthCheckConfig{
+			HealthyThreshold:   healthyThreshold,
+			UnhealthyThreshold: unhealthyThreshold,
+		},
+		metrics:   testMetrics,
+		logger:    log.NewNopLogger(),
+		instances: make(map[string]*instanceStatus),
+	}
+
+	checker.update([]string{"a:80", "b:80"})
+
+	return checker
+}
+
+func TestHealthCheckerThresholds(t *testing.T) {
+	checker := newTestHealthChecker(2, 3)
+	failure := errors.New("health check replied 503")
+
+	steps := []struct {
+		err     error
+		healthy bool
+	}{
+		{failure, true},
+		{failure, true},
+		{failure, false},
+		{failure, false},
+		{nil, false},
+		{failure, false},
+		{nil, false},
+		{nil, true},
+		{failure, true},
+		{nil, true},
+		{failure, true},
+		{failure, true},
+		{failure, false},
+	}
+
+	for i, step := range steps {
+		checker.record("a:80", step.err)
+
+		if checker.Healthy("a:80") != step.healthy {
+			t.Fatalf("step %d: healthy %v, want %v", i, !step.healthy, step.healthy)
+		}
+	}
+
+	if !checker.Healthy("b:80") {
+		t.Error("unprobed instance is unhealthy")
+	}
+}
+
+func TestHealthCheckerReportsInstances(t *testing.T) {
+	checker := newTestHealthChecker(1, 1)
+	checker.record("a:80", errors.New("connection refused"))
+
+	health := checker.Health()
+
+	if !health.Status || len(health.Instances) != 2 {
+		t.Fatalf("unexpected health %+v", health)
+	}
+
+	a, b := health.Instances[0], health.Instances[1]
+
+	if a.Address != "a:80" || a.Healthy || a.Error != "connection refused" || a.LastCheck.IsZero() {
+		t.Errorf("unexpected instance %+v", a)
+	}
+
+	if b.Address != "b:80" || !b.Healthy || !b.LastCheck.IsZero() {
+		t.Errorf("unexpected instance %+v", b)
+	}
+
+	checker.record("b:80", errors.New("connection refused"))
+
+	if checker.Health().Status {
+		t.Error("upstream without healthy instances is healthy")
+	}
+
+	// Instances gone from service discovery are no longer reported
+	checker.update([]string{"b:80"})
+	checker.record("a:80", nil)
+
+	if health := checker.Health(); len(health.Instances) != 1 || !checker.Healthy("a:80") {
+		t.Errorf("unexpected health %+v", health)
+	}
+}
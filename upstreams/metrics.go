@@ -0,0 +1,88 @@
+package upstreams
+
+import (
+	"github.com/go-kit/kit/metrics"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are shared by all upstream clusters and labelled with the upstream name.
+type Metrics struct {
+	BreakerState       metrics.Gauge
+	BreakerTransitions metrics.Counter
+	InFlight           metrics.Gauge
+	Queued             metrics.Gauge
+	ConcurrencyLimit   metrics.Gauge
+	Limited            metrics.Counter
+	Healthy            metrics.Gauge
+	Ejected            metrics.Gauge
+	Ejections          metrics.Counter
+}
+
+func NewMetrics(subsystem string) *Metrics {
+	return &Metrics{
+		BreakerState: kitprometheus.NewGaugeFrom(
+			stdprometheus.GaugeOpts{
+				Name:      "circuit_breaker_state",
+				Subsystem: subsystem,
+				Help:      "Circuit breaker state: 0 closed, 1 half-open, 2 open",
+			},
+			[]string{"upstream"}),
+		BreakerTransitions: kitprometheus.NewCounterFrom(
+			stdprometheus.CounterOpts{
+				Name:      "circuit_breaker_transitions_counter",
+				Subsystem: subsystem,
+				Help:      "Circuit breaker state transitions",
+			},
+			[]string{"upstream", "from", "to"}),
+		InFlight: kitprometheus.NewGaugeFrom(
+			stdprometheus.GaugeOpts{
+				Name:      "upstream_in_flight_gauge",
+				Subsystem: subsystem,
+				Help:      "Calls in flight to upstream",
+			},
+			[]string{"upstream"}),
+		Queued: kitprometheus.NewGaugeFrom(
+			stdprometheus.GaugeOpts{
+				Name:      "upstream_queued_gauge",
+				Subsystem: subsystem,
+				Help:      "Calls waiting for a bulkhead slot of upstream",
+			},
+			[]string{"upstream"}),
+		ConcurrencyLimit: kitprometheus.NewGaugeFrom(
+			stdprometheus.GaugeOpts{
+				Name:      "upstream_concurrency_limit_gauge",
+				Subsystem: subsystem,
+				Help:      "Adaptive concurrency limit of upstream",
+			},
+			[]string{"upstream"}),
+		Limited: kitprometheus.NewCounterFrom(
+			stdprometheus.CounterOpts{
+				Name:      "upstream_limited_counter",
+				Subsystem: subsystem,
+				Help:      "Calls rejected by the adaptive concurrency limit of upstream",
+			},
+			[]string{"upstream"}),
+		Healthy: kitprometheus.NewGaugeFrom(
+			stdprometheus.GaugeOpts{
+				Name:      "upstream_instance_healthy_gauge",
+				Subsystem: subsystem,
+				Help:      "Upstream instance health check state: 1 healthy, 0 unhealthy",
+			},
+			[]string{"upstream", "instance"}),
+		Ejected: kitprometheus.NewGaugeFrom(
+			stdprometheus.GaugeOpts{
+				Name:      "upstream_instance_ejected_gauge",
+				Subsystem: subsystem,
+				Help:      "Upstream instance ejected by outlier detection: 1 ejected, 0 in balancing",
+			},
+			[]string{"upstream", "instance"}),
+		Ejections: kitprometheus.NewCounterFrom(
+			stdprometheus.CounterOpts{
+				Name:      "upstream_instance_ejections_counter",
+				Subsystem: subsystem,
+				Help:      "Upstream instances ejected by outlier detection",
+			},
+			[]string{"upstream", "reason"}),
+	}
+}
@@ -0,0 +1,279 @@
+package upstreams
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reasons an instance is ejected for.
+const (
+	EjectedForErrors  = "consecutive_errors"
+	EjectedForLatency = "latency"
+)
+
+const (
+	defaultOutlierMinRequests        = 20
+	defaultOutlierBaseEjectionTime   = 30 * time.Second
+	defaultOutlierMaxEjectionTime    = 5 * time.Minute
+	defaultOutlierMaxEjectionPercent = 10
+
+	// latencyWeight is the weight of the last call in the average latency of an instance
+	latencyWeight = 0.1
+)
+
+type instanceStats struct {
+	consecutiveErrors int
+	requests          int
+	latency           float64
+	ejections         int
+	ejected           bool
+	ejectedUntil      time.Time
+	reason            string
+}
+
+// OutlierDetector passively tracks calls to the instances of an upstream and
+// temporarily ejects the ones failing or responding much slower than the others.
+type OutlierDetector struct {
+	upstream string
+	config   OutlierDetectionConfig
+	metrics  *Metrics
+	logger   log.Logger
+
+	mtx       sync.Mutex
+	instances map[string]*instanceStats
+}
+
+// newOutlierDetector makes the detector shared by all endpoints of an upstream,
+// it returns nil when outlier detection is disabled.
+func newOutlierDetector(name string, config OutlierDetectionConfig, metrics *Metrics, logger log.Logger) *OutlierDetector {
+	if config.ConsecutiveErrors <= 0 && config.LatencyFactor <= 0 {
+		return nil
+	}
+
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaultOutlierMinRequests
+	}
+
+	if config.BaseEjectionTime.Duration <= 0 {
+		config.BaseEjectionTime.Duration = defaultOutlierBaseEjectionTime
+	}
+
+	if config.MaxEjectionTime.Duration <= 0 {
+		config.MaxEjectionTime.Duration = defaultOutlierMaxEjectionTime
+	}
+
+	if config.MaxEjectionPercent <= 0 {
+		config.MaxEjectionPercent = defaultOutlierMaxEjectionPercent
+	}
+
+	return &OutlierDetector{
+		upstream:  name,
+		config:    config,
+		metrics:   metrics,
+		logger:    logger,
+		instances: make(map[string]*instanceStats),
+	}
+}
+
+// track observes the calls made to instance. Calls cancelled by clients say nothing
+// about the instance and are not counted.
+func (detector *OutlierDetector) track(instance string, next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		begin := time.Now()
+		response, err := next(ctx, request)
+
+		if err != nil && ctx.Err() == context.Canceled {
+			return response, err
+		}
+
+		failed := err != nil
+
+		if proxyResponse, ok := response.(ProxyResponse); ok && proxyResponse.StatusCode >= http.StatusInternalServerError {
+			failed = true
+		}
+
+		detector.observe(instance, time.Since(begin), failed)
+
+		return response, err
+	}
+}
+
+// update follows the instances reported by service discovery.
+func (detector *OutlierDetector) update(instances []string) {
+	detector.mtx.Lock()
+	defer detector.mtx.Unlock()
+
+	known := make(map[string]bool, len(instances))
+
+	for _, instance := range instances {
+		known[instance] = true
+
+		if _, ok := detector.instances[instance]; !ok {
+			detector.instances[instance] = &instanceStats{}
+		}
+	}
+
+	for instance, health := range detector.instances {
+		if !known[instance] {
+			if health.ejected {
+				detector.metrics.Ejected.With("upstream", detector.upstream, "instance", instance).Set(0)
+			}
+
+			delete(detector.instances, instance)
+		}
+	}
+}
+
+// Ejected reports whether instance is ejected, re-admitting it once its ejection time is over.
+func (detector *OutlierDetector) Ejected(instance string) bool {
+	detector.mtx.Lock()
+	defer detector.mtx.Unlock()
+
+	health, ok := detector.instances[instance]
+
+	if !ok || !health.ejected {
+		return false
+	}
+
+	if time.Now().Before(health.ejectedUntil) {
+		return true
+	}
+
+	health.ejected = false
+	health.consecutiveErrors = 0
+	detector.metrics.Ejected.With("upstream", detector.upstream, "instance", instance).Set(0)
+	detector.logger.Log("msg", "instance re-admitted", "instance", instance)
+
+	return false
+}
+
+func (detector *OutlierDetector) observe(instance string, latency time.Duration, failed bool) {
+	detector.mtx.Lock()
+	defer detector.mtx.Unlock()
+
+	health, ok := detector.instances[instance]
+
+	if !ok || health.ejected {
+		return
+	}
+
+	if failed {
+		health.consecutiveErrors++
+
+		if detector.config.ConsecutiveErrors > 0 && health.consecutiveErrors >= detector.config.ConsecutiveErrors {
+			detector.eject(instance, health, EjectedForErrors)
+		}
+
+		return
+	}
+
+	health.consecutiveErrors = 0
+	health.requests++
+
+	if health.requests == 1 {
+		health.latency = latency.Seconds()
+	} else {
+		health.latency += latencyWeight * (latency.Seconds() - health.latency)
+	}
+
+	// Instances healthy for a whole MaxEjectionTime start over with the base ejection time
+	if health.ejections > 0 && time.Now().After(health.ejectedUntil.Add(detector.config.MaxEjectionTime.Duration)) {
+		health.ejections = 0
+	}
+
+	if detector.config.LatencyFactor > 0 && health.requests >= detector.config.MinRequests {
+		if average, ok := detector.averageLatency(instance); ok && health.latency > detector.config.LatencyFactor*average {
+			detector.eject(instance, health, EjectedForLatency)
+		}
+	}
+}
+
+// averageLatency is the average latency of the other instances in balancing with enough calls.
+func (detector *OutlierDetector) averageLatency(except string) (float64, bool) {
+	var sum float64
+	var count int
+
+	for instance, health := range detector.instances {
+		if instance != except && !health.ejected && health.requests >= detector.config.MinRequests {
+			sum += health.latency
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, false
+	}
+
+	return sum / float64(count), true
+}
+
+// eject takes instance out of balancing for the base ejection time doubled with every
+// previous ejection. At most MaxEjectionPercent of the instances are ejected at once,
+// though one of several instances may always be.
+func (detector *OutlierDetector) eject(instance string, health *instanceStats, reason string) {
+	now := time.Now()
+	ejected := 0
+
+	for _, other := range detector.instances {
+		if other.ejected && now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+
+	total := len(detector.instances)
+
+	if float64(ejected+1)*100 > float64(total)*detector.config.MaxEjectionPercent && (ejected > 0 || total < 2) {
+		return
+	}
+
+	ejectionTime := detector.config.BaseEjectionTime.Duration << uint(health.ejections)
+
+	if ejectionTime > detector.config.MaxEjectionTime.Duration || ejectionTime <= 0 {
+		ejectionTime = detector.config.MaxEjectionTime.Duration
+	}
+
+	health.ejected = true
+	health.ejections++
+	health.ejectedUntil = now.Add(ejectionTime)
+	health.reason = reason
+	health.requests = 0
+
+	detector.metrics.Ejected.With("upstream", detector.upstream, "instance", instance).Set(1)
+	detector.metrics.Ejections.With("upstream", detector.upstream, "reason", reason).Add(1)
+	detector.logger.Log("msg", "instance ejected", "instance", instance, "reason", reason, "for", ejectionTime)
+}
+
+// Ejections lists the instances currently ejected.
+func (detector *OutlierDetector) Ejections() []EjectedInstance {
+	detector.mtx.Lock()
+	defer detector.mtx.Unlock()
+
+	now := time.Now()
+	ejections := make([]EjectedInstance, 0)
+
+	for instance, health := range detector.instances {
+		if health.ejected && now.Before(health.ejectedUntil) {
+			ejections = append(ejections, EjectedInstance{
+				Upstream:  detector.upstream,
+				Instance:  instance,
+				Reason:    health.reason,
+				Ejections: health.ejections,
+				Until:     health.ejectedUntil,
+			})
+		}
+	}
+
+	sort.Slice(ejections, func(i, j int) bool {
+		return ejections[i].Instance < ejections[j].Instance
+	})
+
+	return ejections
+}
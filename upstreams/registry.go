@@ -0,0 +1,96 @@
+package upstreams
+
+import (
+	. "api-gateway"
+	. "api-gateway/data"
+
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd/consul"
+	"github.com/pkg/errors"
+	"sort"
+)
+
+// Registry holds the upstream clusters by name.
+type Registry struct {
+	clusters map[string]*Cluster
+}
+
+func NewRegistry(configs []UpstreamConfig, consulClient consul.Client, metrics *Metrics,
+	logger log.Logger) (*Registry, error) {
+	registry := &Registry{
+		clusters: make(map[string]*Cluster, len(configs)),
+	}
+
+	for _, config := range configs {
+		if _, ok := registry.clusters[config.Name]; ok {
+			registry.Close()
+			return nil, errors.New(fmt.Sprintf("duplicate upstream %s", config.Name))
+		}
+
+		cluster, err := NewCluster(config, consulClient, metrics, logger)
+
+		if err != nil {
+			registry.Close()
+			return nil, err
+		}
+
+		registry.clusters[config.Name] = cluster
+	}
+
+	return registry, nil
+}
+
+func (registry *Registry) Get(name string) (*Cluster, error) {
+	cluster, ok := registry.clusters[name]
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown upstream %q", name))
+	}
+
+	return cluster, nil
+}
+
+func (registry *Registry) Clusters() []*Cluster {
+	clusters := make([]*Cluster, 0, len(registry.clusters))
+
+	for _, cluster := range registry.clusters {
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	return clusters
+}
+
+// Health reports the upstreams that are checked actively.
+func (registry *Registry) Health() []UpstreamHealth {
+	health := make([]UpstreamHealth, 0)
+
+	for _, cluster := range registry.Clusters() {
+		if cluster.checker != nil {
+			health = append(health, cluster.checker.Health())
+		}
+	}
+
+	return health
+}
+
+// Ejections lists the instances of all upstreams ejected by outlier detection.
+func (registry *Registry) Ejections() []EjectedInstance {
+	ejections := make([]EjectedInstance, 0)
+
+	for _, cluster := range registry.Clusters() {
+		ejections = append(ejections, cluster.Ejections()...)
+	}
+
+	return ejections
+}
+
+func (registry *Registry) Close() {
+	for _, cluster := range registry.clusters {
+		cluster.Close()
+	}
+}